@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"gitlab.com/TECHNOFAB/nixtest/internal/config"
+	appnix "gitlab.com/TECHNOFAB/nixtest/internal/nix"
+	"gitlab.com/TECHNOFAB/nixtest/internal/runner"
+	"gitlab.com/TECHNOFAB/nixtest/internal/types"
+
+	"github.com/rs/zerolog/log"
+)
+
+// runDebug drops into an interactive session for the test named <suite>/<test>,
+// a shell in the prepared working directory for script tests or the
+// interactive NixOS test driver for vm tests
+func runDebug(appCfg config.AppConfig, nixService *appnix.DefaultService, suites []types.SuiteSpec, target string) error {
+	spec, found := findTest(suites, target)
+	if !found {
+		return fmt.Errorf("test %q not found, expected <suite>/<test>", target)
+	}
+
+	var result appnix.ScriptResult
+	var err error
+	switch spec.Type {
+	case types.TestTypeVM:
+		result, err = nixService.DebugVM(spec.Driver)
+	case types.TestTypeScript:
+		result, err = nixService.DebugScript(spec.Script, appnix.ScriptOptions{
+			ImpureEnv:   appCfg.ImpureEnv,
+			Fixtures:    spec.Fixtures,
+			Interpreter: spec.Interpreter,
+			Entrypoint:  spec.Entrypoint,
+			Args:        spec.Args,
+		})
+	default:
+		return fmt.Errorf("test %q is of type %s, only script and vm tests can be debugged", target, spec.Type)
+	}
+
+	for _, dir := range []string{result.WorkDir, result.ArtifactsDir} {
+		if dir == "" {
+			continue
+		}
+		if appCfg.KeepTmp != runner.KeepTmpNever {
+			log.Info().Str("dir", dir).Msg("Keeping directory")
+			continue
+		}
+		if removeErr := os.RemoveAll(dir); removeErr != nil {
+			log.Warn().Err(removeErr).Str("dir", dir).Msg("Failed to remove directory")
+		}
+	}
+	return err
+}
+
+// findTest looks up a test by <suite>/<test>
+func findTest(suites []types.SuiteSpec, target string) (types.TestSpec, bool) {
+	for _, suite := range suites {
+		for _, test := range suite.Tests {
+			if suite.Name+"/"+test.Name == target {
+				test.Suite = suite.Name
+				return test, true
+			}
+		}
+	}
+	return types.TestSpec{}, false
+}
@@ -55,12 +55,29 @@ func main() {
 	nixService := appnix.NewDefaultService()
 	snapshotService := appsnap.NewDefaultService()
 
+	if len(appCfg.Args) > 0 {
+		if appCfg.Args[0] != "debug" || len(appCfg.Args) != 2 {
+			log.Error().Strs("args", appCfg.Args).Msg("Unknown command, expected 'debug <suite>/<test>'")
+			os.Exit(1)
+		}
+		if err := runDebug(appCfg, nixService, suites, appCfg.Args[1]); err != nil {
+			log.Error().Err(err).Msg("Failed to debug test")
+			os.Exit(1)
+		}
+		return
+	}
+
 	runnerCfg := runner.Config{
 		NumWorkers:      appCfg.NumWorkers,
 		SnapshotDir:     appCfg.SnapshotDir,
 		UpdateSnapshots: appCfg.UpdateSnapshots,
 		SkipPattern:     appCfg.SkipPattern,
 		ImpureEnv:       appCfg.ImpureEnv,
+		KeepTmp:         appCfg.KeepTmp,
+		ArtifactsDir:    appCfg.ArtifactsDir,
+		OutputLimit:     appCfg.OutputLimit,
+		OutputSpillDir:  appCfg.OutputSpillDir,
+		Verbose:         appCfg.Verbose,
 	}
 	testRunner, err := runner.New(runnerCfg, nixService, snapshotService)
 	if err != nil {
@@ -88,8 +105,13 @@ func main() {
 	}
 
 	// print errors first then summary
-	console.PrintErrors(results, appCfg.NoColor)
-	console.PrintSummary(results, relevantSuccessCount, totalTests)
+	console.PrintErrors(results, util.DiffOptions{
+		Style:    util.DiffStyle(appCfg.DiffStyle),
+		Context:  appCfg.DiffContext,
+		Color:    !appCfg.NoColor,
+		MaxLines: appCfg.MaxDiffLines,
+	})
+	console.PrintSummary(results, relevantSuccessCount, totalTests, appCfg.Stats)
 
 	if relevantSuccessCount != totalTests {
 		log.Error().Msgf("Test run finished with failures or errors. %d/%d successful (includes skipped).", relevantSuccessCount, totalTests)
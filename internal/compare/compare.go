@@ -0,0 +1,491 @@
+package compare
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gitlab.com/TECHNOFAB/nixtest/internal/types"
+)
+
+// maximum length of values shown in mismatch messages
+const maxValueLength = 80
+
+// lists with more element pairs than this are compared by index instead of
+// being aligned, as aligning compares every element with every other one
+const maxAlignCells = 250_000
+
+var identifierRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
+
+// Options control how values are compared
+type Options struct {
+	// Matchers interprets matchers in expected (see matchers.go), only
+	// expected values written by the user should enable it. Object keys
+	// starting with "$$" stand for keys starting with a literal "$".
+	Matchers bool
+	// Subset only requires expected to be contained in actual, extra keys
+	// of objects in actual are ignored
+	Subset bool
+	// SubsetLists additionally allows lists in actual to contain more elements
+	// (in any order) than expected, only used with Subset
+	SubsetLists bool
+	// UnorderedLists compares lists as multisets, the order of elements
+	// doesn't matter but their count does
+	UnorderedLists bool
+	// IgnoreWhitespace treats every run of whitespace in strings as a single
+	// space and ignores leading and trailing whitespace
+	IgnoreWhitespace bool
+	// IgnoreCase compares strings case-insensitively
+	IgnoreCase bool
+	// IgnoreTrailingNewline ignores trailing newlines of strings
+	IgnoreTrailingNewline bool
+	// Tolerance allows numbers to differ slightly
+	Tolerance Tolerance
+}
+
+// Tolerance of numeric comparisons, numbers are equal if they differ by at
+// most Absolute or by at most Relative times the larger magnitude
+type Tolerance struct {
+	Absolute float64
+	Relative float64
+}
+
+// Result of a comparison
+type Result struct {
+	// Expected is expected with every satisfied matcher replaced by the actual
+	// value, so a diff against Actual only shows real differences
+	Expected any
+	// Actual is actual reduced to what expected describes in subset mode
+	Actual any
+	// Mismatches contains every difference
+	Mismatches []types.Mismatch
+}
+
+// Compare checks actual against expected, which may contain matchers anywhere
+// if enabled (see matchers.go). Invalid matchers result in an error.
+func Compare(expected, actual any, opts Options) (Result, error) {
+	c := &comparer{opts: opts}
+	resolved, projected, err := c.compare("$", expected, actual)
+	if err != nil {
+		return Result{}, err
+	}
+	return Result{Expected: resolved, Actual: projected, Mismatches: c.mismatches}, nil
+}
+
+type comparer struct {
+	opts       Options
+	mismatches []types.Mismatch
+}
+
+func (c *comparer) mismatch(kind types.MismatchKind, path string, format string, args ...any) {
+	c.mismatches = append(c.mismatches, types.Mismatch{Kind: kind, Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// compare returns the resolved expected and projected actual value
+func (c *comparer) compare(path string, expected, actual any) (any, any, error) {
+	if c.opts.Matchers {
+		if m, ok := asMatcher(expected); ok {
+			resolved, err := c.compareMatcher(path, m, actual)
+			return resolved, actual, err
+		}
+	}
+
+	switch exp := expected.(type) {
+	case map[string]any:
+		act, ok := actual.(map[string]any)
+		if !ok {
+			break
+		}
+		return c.compareMaps(path, exp, act)
+	case []any:
+		act, ok := actual.([]any)
+		if !ok {
+			break
+		}
+		if c.opts.Subset && c.opts.SubsetLists {
+			return c.compareListUnordered(path, exp, act, true)
+		}
+		if c.opts.UnorderedLists {
+			return c.compareListUnordered(path, exp, act, false)
+		}
+		return c.compareLists(path, exp, act)
+	case float64, json.Number:
+		if _, ok := toFloat(actual); ok {
+			return c.compareNumbers(path, expected, actual)
+		}
+	case string:
+		act, ok := actual.(string)
+		if ok && exp != act && c.normalizeString(exp) == c.normalizeString(act) {
+			// equal according to the string options, so the diff shouldn't show it
+			return act, act, nil
+		}
+		if ok && exp != act && path != "$" && (strings.Contains(exp, "\n") || strings.Contains(act, "\n")) {
+			// shown as text diff below the structural diff, the root string is
+			// shown as text diff anyway
+			c.mismatches = append(c.mismatches, types.Mismatch{
+				Kind:     types.MismatchChanged,
+				Path:     path,
+				Message:  "text differs, see the diff below",
+				Expected: exp,
+				Actual:   act,
+			})
+			return expected, actual, nil
+		}
+	}
+
+	if !reflect.DeepEqual(expected, actual) {
+		c.valueMismatch(path, expected, actual)
+	}
+	return expected, actual, nil
+}
+
+// compareNumbers compares numbers within the tolerance. Exactly decoded
+// integers (json.Number) are compared without rounding.
+func (c *comparer) compareNumbers(path string, expected, actual any) (any, any, error) {
+	if numbersEqual(expected, actual) {
+		return expected, actual, nil
+	}
+	tol := c.opts.Tolerance
+	if tol == (Tolerance{}) {
+		c.valueMismatch(path, expected, actual)
+		return expected, actual, nil
+	}
+
+	exp, _ := toFloat(expected)
+	act, _ := toFloat(actual)
+	diff := math.Abs(exp - act)
+	if diff <= tol.Absolute || diff <= tol.Relative*math.Max(math.Abs(exp), math.Abs(act)) {
+		// equal within the tolerance, so the diff shouldn't show it
+		return actual, actual, nil
+	}
+	c.mismatch(types.MismatchChanged, path, "expected %s, got %s (difference %.6g exceeds the tolerance)", formatValue(expected), formatValue(actual), diff)
+	return expected, actual, nil
+}
+
+// numbersEqual compares two numbers exactly
+func numbersEqual(a, b any) bool {
+	aNumber, aExact := a.(json.Number)
+	bNumber, bExact := b.(json.Number)
+	if aExact && bExact {
+		aRat, aOk := new(big.Rat).SetString(aNumber.String())
+		bRat, bOk := new(big.Rat).SetString(bNumber.String())
+		if aOk && bOk {
+			return aRat.Cmp(bRat) == 0
+		}
+	}
+	aFloat, aOk := toFloat(a)
+	bFloat, bOk := toFloat(b)
+	return aOk && bOk && aFloat == bFloat
+}
+
+// toFloat converts a decoded JSON number to float64
+func toFloat(value any) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
+
+// valueMismatch records a changed value. Values of different types are
+// reported with both types, as 1 and "1" look nearly identical otherwise
+func (c *comparer) valueMismatch(path string, expected, actual any) {
+	expectedType, actualType := typeName(expected), typeName(actual)
+	if expectedType == actualType {
+		c.mismatch(types.MismatchChanged, path, "expected %s, got %s", formatValue(expected), formatValue(actual))
+		return
+	}
+	c.mismatch(types.MismatchChanged, path, "type mismatch: expected %s, got %s", formatTyped(expected), formatTyped(actual))
+}
+
+func (c *comparer) compareMaps(path string, exp, act map[string]any) (any, any, error) {
+	if c.opts.Matchers {
+		exp = unescapeKeys(exp)
+	}
+	resolved := make(map[string]any, len(exp))
+	projected := make(map[string]any, len(act))
+	for _, key := range sortedKeys(exp) {
+		actValue, found := act[key]
+		if !found {
+			if exp[key] == nil {
+				c.mismatch(types.MismatchRemoved, pathKey(path, key), "missing key, expected null (a missing key is not null)")
+			} else {
+				c.mismatch(types.MismatchRemoved, pathKey(path, key), "missing key, expected %s", formatValue(exp[key]))
+			}
+			resolved[key] = exp[key]
+			continue
+		}
+		value, projectedValue, err := c.compare(pathKey(path, key), exp[key], actValue)
+		if err != nil {
+			return nil, nil, err
+		}
+		resolved[key] = value
+		projected[key] = projectedValue
+	}
+	for _, key := range sortedKeys(act) {
+		if _, found := exp[key]; found {
+			continue
+		}
+		if !c.opts.Subset {
+			if act[key] == nil {
+				c.mismatch(types.MismatchAdded, pathKey(path, key), "unexpected key with value null (null is not a missing key)")
+			} else {
+				c.mismatch(types.MismatchAdded, pathKey(path, key), "unexpected key with value %s", formatValue(act[key]))
+			}
+			projected[key] = act[key]
+		}
+	}
+	return resolved, projected, nil
+}
+
+// compareLists aligns the elements of both lists (longest common subsequence
+// of matching elements), so an inserted or removed element is reported as
+// such instead of as a change of every following element
+func (c *comparer) compareLists(path string, exp, act []any) (any, any, error) {
+	resolved := make([]any, len(exp))
+	copy(resolved, exp)
+	projected := make([]any, len(act))
+	copy(projected, act)
+
+	pairs, err := c.alignLists(exp, act)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	i, j := 0, 0
+	// the final sentinel pair flushes the remaining elements
+	for _, pair := range append(pairs, [2]int{len(exp), len(act)}) {
+		// unmatched elements between two aligned pairs are compared by position,
+		// the rest was removed or added
+		for ; i < pair[0] && j < pair[1]; i, j = i+1, j+1 {
+			value, projectedValue, err := c.compare(pathIndex(path, i), exp[i], act[j])
+			if err != nil {
+				return nil, nil, err
+			}
+			resolved[i] = value
+			projected[j] = projectedValue
+		}
+		for ; i < pair[0]; i++ {
+			c.mismatch(types.MismatchRemoved, pathIndex(path, i), "missing element, expected %s", formatValue(exp[i]))
+		}
+		for ; j < pair[1]; j++ {
+			c.mismatch(types.MismatchAdded, pathIndex(path, j), "unexpected element %s", formatValue(act[j]))
+		}
+		if i < len(exp) && j < len(act) {
+			_, value, projectedValue, err := c.matches(exp[i], act[j])
+			if err != nil {
+				return nil, nil, err
+			}
+			resolved[i] = value
+			projected[j] = projectedValue
+			i, j = i+1, j+1
+		}
+	}
+	return resolved, projected, nil
+}
+
+// alignLists returns the index pairs of the longest common subsequence of
+// matching elements, in order. Lists too big to align are paired by index.
+func (c *comparer) alignLists(exp, act []any) ([][2]int, error) {
+	if len(exp)*len(act) > maxAlignCells {
+		return nil, nil
+	}
+
+	// lengths[i][j] is the length of the common subsequence of exp[i:] and act[j:]
+	lengths := make([][]int, len(exp)+1)
+	equal := make([][]bool, len(exp))
+	for i := range lengths {
+		lengths[i] = make([]int, len(act)+1)
+	}
+	for i := len(exp) - 1; i >= 0; i-- {
+		equal[i] = make([]bool, len(act))
+		for j := len(act) - 1; j >= 0; j-- {
+			ok, _, _, err := c.matches(exp[i], act[j])
+			if err != nil {
+				return nil, err
+			}
+			equal[i][j] = ok
+			switch {
+			case ok:
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			case lengths[i+1][j] >= lengths[i][j+1]:
+				lengths[i][j] = lengths[i+1][j]
+			default:
+				lengths[i][j] = lengths[i][j+1]
+			}
+		}
+	}
+
+	var pairs [][2]int
+	for i, j := 0, 0; i < len(exp) && j < len(act); {
+		switch {
+		case equal[i][j]:
+			pairs = append(pairs, [2]int{i, j})
+			i, j = i+1, j+1
+		case lengths[i+1][j] >= lengths[i][j+1]:
+			i++
+		default:
+			j++
+		}
+	}
+	return pairs, nil
+}
+
+// compareListUnordered checks that every expected element matches a distinct
+// element of actual, in any order. Remaining elements of actual are
+// unexpected unless allowExtra is set. Elements are paired by a maximum
+// bipartite matching, as with matchers or tolerances an expected element can
+// match several actual elements and the first one might be needed by another.
+func (c *comparer) compareListUnordered(path string, exp, act []any, allowExtra bool) (any, any, error) {
+	type candidate struct {
+		act                 int
+		resolved, projected any
+	}
+	candidates := make([][]candidate, len(exp))
+	for i := range exp {
+		for j := range act {
+			ok, value, projectedValue, err := c.matches(exp[i], act[j])
+			if err != nil {
+				return nil, nil, err
+			}
+			if ok {
+				candidates[i] = append(candidates[i], candidate{j, value, projectedValue})
+			}
+		}
+	}
+
+	// matchedBy[j] is the index of the expected element paired with act[j] (or -1)
+	matchedBy := make([]int, len(act))
+	for j := range matchedBy {
+		matchedBy[j] = -1
+	}
+	// augment tries to pair exp[i], re-pairing already paired elements if needed
+	var augment func(i int, visited []bool) bool
+	augment = func(i int, visited []bool) bool {
+		for _, cand := range candidates[i] {
+			if visited[cand.act] {
+				continue
+			}
+			visited[cand.act] = true
+			if matchedBy[cand.act] == -1 || augment(matchedBy[cand.act], visited) {
+				matchedBy[cand.act] = i
+				return true
+			}
+		}
+		return false
+	}
+	for i := range exp {
+		augment(i, make([]bool, len(act)))
+	}
+
+	pairedWith := make([]int, len(exp))
+	for i := range pairedWith {
+		pairedWith[i] = -1
+	}
+	for j, i := range matchedBy {
+		if i != -1 {
+			pairedWith[i] = j
+		}
+	}
+
+	resolved := make([]any, len(exp))
+	projected := make([]any, 0, len(act))
+	for i := range exp {
+		resolved[i] = exp[i]
+		if pairedWith[i] == -1 {
+			c.mismatch(types.MismatchRemoved, pathIndex(path, i), "no element in actual matches %s", formatValue(exp[i]))
+			continue
+		}
+		for _, cand := range candidates[i] {
+			if cand.act == pairedWith[i] {
+				resolved[i] = cand.resolved
+				projected = append(projected, cand.projected)
+				break
+			}
+		}
+	}
+	if allowExtra {
+		return resolved, projected, nil
+	}
+	for j := range act {
+		if matchedBy[j] == -1 {
+			c.mismatch(types.MismatchAdded, pathIndex(path, j), "unexpected element %s", formatValue(act[j]))
+			projected = append(projected, act[j])
+		}
+	}
+	return resolved, projected, nil
+}
+
+// normalizeString applies the string options
+func (c *comparer) normalizeString(s string) string {
+	if c.opts.IgnoreTrailingNewline {
+		s = strings.TrimRight(s, "\n")
+	}
+	if c.opts.IgnoreWhitespace {
+		s = strings.Join(strings.Fields(s), " ")
+	}
+	if c.opts.IgnoreCase {
+		s = strings.ToLower(s)
+	}
+	return s
+}
+
+// matches reports whether actual matches expected without recording mismatches
+func (c *comparer) matches(expected, actual any) (bool, any, any, error) {
+	child := &comparer{opts: c.opts}
+	resolved, projected, err := child.compare("$", expected, actual)
+	if err != nil {
+		return false, nil, nil, err
+	}
+	return len(child.mismatches) == 0, resolved, projected, nil
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func pathKey(path string, key string) string {
+	if identifierRegex.MatchString(key) {
+		return path + "." + key
+	}
+	return path + "[" + strconv.Quote(key) + "]"
+}
+
+func pathIndex(path string, index int) string {
+	return fmt.Sprintf("%s[%d]", path, index)
+}
+
+// formatTyped renders a value prefixed with its type, like `string "1"`
+func formatTyped(value any) string {
+	if value == nil {
+		return "null"
+	}
+	return typeName(value) + " " + formatValue(value)
+}
+
+// formatValue renders a value as (shortened) JSON for mismatch messages
+func formatValue(value any) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	if len(data) > maxValueLength {
+		return string(data[:maxValueLength-3]) + "..."
+	}
+	return string(data)
+}
@@ -0,0 +1,368 @@
+package compare
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gitlab.com/TECHNOFAB/nixtest/internal/types"
+)
+
+func decode(t *testing.T, data string) any {
+	t.Helper()
+	var value any
+	if err := json.Unmarshal([]byte(data), &value); err != nil {
+		t.Fatalf("failed to decode %s: %v", data, err)
+	}
+	return value
+}
+
+func decodeExact(t *testing.T, data string) any {
+	t.Helper()
+	var value any
+	decoder := json.NewDecoder(strings.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		t.Fatalf("failed to decode %s: %v", data, err)
+	}
+	return value
+}
+
+func TestCompare_ExactNumbers(t *testing.T) {
+	tests := []struct {
+		name           string
+		expected       string
+		actual         string
+		opts           Options
+		wantMismatches []types.Mismatch
+	}{
+		{
+			name:     "Equal large integers",
+			expected: `{"id": 9007199254740993, "ratio": 0.5, "exp": 1e3}`,
+			actual:   `{"id": 9007199254740993, "ratio": 0.50, "exp": 1000}`,
+		},
+		{
+			name:     "Large integers differing beyond float precision",
+			expected: `9007199254740993`,
+			actual:   `9007199254740992`,
+			wantMismatches: []types.Mismatch{
+				{Path: "$", Message: "expected 9007199254740993, got 9007199254740992"},
+			},
+		},
+		{
+			name:     "Type mismatch",
+			expected: `1`,
+			actual:   `1.5`,
+			wantMismatches: []types.Mismatch{
+				{Path: "$", Message: "type mismatch: expected int 1, got float 1.5"},
+			},
+		},
+		{
+			name:     "Tolerance",
+			expected: `[1, {"$approx": 2, "$tol": 0.5}, {"$type": "int"}]`,
+			actual:   `[1.05, 2.25, 3]`,
+			opts:     Options{Matchers: true, Tolerance: Tolerance{Absolute: 0.1}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := Compare(decodeExact(t, tt.expected), decodeExact(t, tt.actual), tt.opts)
+			if err != nil {
+				t.Fatalf("Compare() error = %v", err)
+			}
+			if !reflect.DeepEqual(result.Mismatches, tt.wantMismatches) {
+				t.Errorf("Compare() mismatches = %#v, want %#v", result.Mismatches, tt.wantMismatches)
+			}
+		})
+	}
+}
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		name           string
+		expected       string
+		actual         string
+		wantResolved   string
+		wantMismatches []types.Mismatch
+		wantErr        bool
+		opts           Options
+		wantActual     string
+	}{
+		{
+			name:     "Equal",
+			expected: `{"a": [1, "b", null]}`,
+			actual:   `{"a": [1, "b", null]}`,
+		},
+		{
+			name:     "Different values",
+			expected: `{"a": 1, "b": {"c": "x"}, "with space": true}`,
+			actual:   `{"a": 2, "b": {"c": "y"}, "with space": true}`,
+			wantMismatches: []types.Mismatch{
+				{Path: "$.a", Message: "expected 1, got 2"},
+				{Path: "$.b.c", Message: `expected "x", got "y"`},
+			},
+		},
+		{
+			name:     "Multi-line strings",
+			expected: `{"a": "x\ny\n", "b": "x"}`,
+			actual:   `{"a": "x\nz\n", "b": "x\ny"}`,
+			wantMismatches: []types.Mismatch{
+				{Path: "$.a", Message: "text differs, see the diff below", Expected: "x\ny\n", Actual: "x\nz\n"},
+				{Path: "$.b", Message: "text differs, see the diff below", Expected: "x", Actual: "x\ny"},
+			},
+		},
+		{
+			name:     "Multi-line root string",
+			expected: `"x\ny"`,
+			actual:   `"x\nz"`,
+			wantMismatches: []types.Mismatch{
+				{Path: "$", Message: `expected "x\ny", got "x\nz"`},
+			},
+		},
+		{
+			name:     "Missing and unexpected keys",
+			expected: `{"a": 1, "b": 2}`,
+			actual:   `{"a": 1, "with space": 3}`,
+			wantMismatches: []types.Mismatch{
+				{Kind: types.MismatchRemoved, Path: "$.b", Message: "missing key, expected 2"},
+				{Kind: types.MismatchAdded, Path: `$["with space"]`, Message: "unexpected key with value 3"},
+			},
+		},
+		{
+			name:     "List lengths",
+			expected: `[1, 2, 3]`,
+			actual:   `[1, 5]`,
+			wantMismatches: []types.Mismatch{
+				{Path: "$[1]", Message: "expected 2, got 5"},
+				{Kind: types.MismatchRemoved, Path: "$[2]", Message: "missing element, expected 3"},
+			},
+		},
+		{
+			name:     "List elements inserted and removed",
+			expected: `[1, 2, 3, 4, 5, 6]`,
+			actual:   `[0, 1, 2, 4, 5, 7, 6]`,
+			wantMismatches: []types.Mismatch{
+				{Kind: types.MismatchAdded, Path: "$[0]", Message: "unexpected element 0"},
+				{Kind: types.MismatchRemoved, Path: "$[2]", Message: "missing element, expected 3"},
+				{Kind: types.MismatchAdded, Path: "$[5]", Message: "unexpected element 7"},
+			},
+		},
+		{
+			name:     "List elements aligned by matchers",
+			opts:     Options{Matchers: true},
+			expected: `[{"$type": "int"}, {"name": "a"}, {"name": "b"}]`,
+			actual:   `[1, {"name": "b"}]`,
+			wantMismatches: []types.Mismatch{
+				{Kind: types.MismatchRemoved, Path: "$[1]", Message: `missing element, expected {"name":"a"}`},
+			},
+			wantResolved: `[1, {"name": "a"}, {"name": "b"}]`,
+		},
+		{
+			name:     "Different types",
+			expected: `{"a": [1]}`,
+			actual:   `{"a": {"b": 1}}`,
+			wantMismatches: []types.Mismatch{
+				{Path: "$.a", Message: `type mismatch: expected list [1], got set {"b":1}`},
+			},
+		},
+		{
+			name:     "Different scalar types",
+			expected: `{"num": 1, "str": "1", "int": 2, "bool": false}`,
+			actual:   `{"num": "1", "str": null, "int": 2.5, "bool": 0}`,
+			wantMismatches: []types.Mismatch{
+				{Path: "$.bool", Message: "type mismatch: expected bool false, got int 0"},
+				{Path: "$.int", Message: "type mismatch: expected int 2, got float 2.5"},
+				{Path: "$.num", Message: `type mismatch: expected int 1, got string "1"`},
+				{Path: "$.str", Message: `type mismatch: expected string "1", got null`},
+			},
+		},
+		{
+			name:     "Null and missing keys",
+			expected: `{"a": null}`,
+			actual:   `{"b": null}`,
+			wantMismatches: []types.Mismatch{
+				{Kind: types.MismatchRemoved, Path: "$.a", Message: "missing key, expected null (a missing key is not null)"},
+				{Kind: types.MismatchAdded, Path: "$.b", Message: "unexpected key with value null (null is not a missing key)"},
+			},
+		},
+		{
+			name:         "Satisfied matchers",
+			opts:         Options{Matchers: true},
+			expected:     `{"version": {"$regex": "^v\\d+"}, "id": {"$any": true}, "name": {"$type": "string"}, "tags": {"$contains": {"$regex": "^b"}}, "ratio": {"$approx": 0.5, "$tol": 0.01}, "count": {"$type": "int"}}`,
+			actual:       `{"version": "v12", "id": 123, "name": "nixtest", "tags": ["a", "bc"], "ratio": 0.505, "count": 3}`,
+			wantResolved: `{"version": "v12", "id": 123, "name": "nixtest", "tags": ["a", "bc"], "ratio": 0.505, "count": 3}`,
+		},
+		{
+			name:         "Failing matchers",
+			opts:         Options{Matchers: true},
+			expected:     `{"version": {"$regex": "^v\\d+"}, "name": {"$type": "list"}, "desc": {"$contains": "foo"}, "ratio": {"$approx": 0.5}, "ok": {"$any": true}}`,
+			actual:       `{"version": "12", "name": "nixtest", "desc": "bar", "ratio": 0.6, "ok": false}`,
+			wantResolved: `{"version": {"$regex": "^v\\d+"}, "name": {"$type": "list"}, "desc": {"$contains": "foo"}, "ratio": {"$approx": 0.5}, "ok": false}`,
+			wantMismatches: []types.Mismatch{
+				{Path: "$.desc", Message: `$contains failed: "bar" does not contain "foo"`},
+				{Path: "$.name", Message: `$type failed: expected type list, got string ("nixtest")`},
+				{Path: "$.ratio", Message: "$approx failed: 0.6 is not within 1e-09 of 0.5"},
+				{Path: "$.version", Message: `$regex failed: "12" does not match regex "^v\\d+"`},
+			},
+		},
+		{
+			name:     "List does not contain",
+			opts:     Options{Matchers: true},
+			expected: `{"$contains": 3}`,
+			actual:   `[1, 2]`,
+			wantMismatches: []types.Mismatch{
+				{Path: "$", Message: "$contains failed: list does not contain an element matching 3"},
+			},
+		},
+		{
+			name:       "Subset",
+			expected:   `{"a": 1, "nested": {"b": [{"c": 1}]}}`,
+			actual:     `{"a": 1, "extra": true, "nested": {"b": [{"c": 1, "d": 2}], "e": 3}}`,
+			opts:       Options{Subset: true},
+			wantActual: `{"a": 1, "nested": {"b": [{"c": 1}]}}`,
+		},
+		{
+			name:     "Subset with missing and differing keys",
+			expected: `{"a": 1, "b": {"c": 2}, "list": [1, 2]}`,
+			actual:   `{"a": 2, "b": {"d": 3}, "list": [1, 2, 3], "extra": true}`,
+			opts:     Options{Subset: true},
+			wantMismatches: []types.Mismatch{
+				{Path: "$.a", Message: "expected 1, got 2"},
+				{Kind: types.MismatchRemoved, Path: "$.b.c", Message: "missing key, expected 2"},
+				{Kind: types.MismatchAdded, Path: "$.list[2]", Message: "unexpected element 3"},
+			},
+			wantActual: `{"a": 2, "b": {}, "list": [1, 2, 3]}`,
+		},
+		{
+			name:         "Subset lists",
+			expected:     `{"list": [{"name": "b"}, {"$type": "int"}]}`,
+			actual:       `{"list": [1, {"name": "a", "x": 1}, {"name": "b", "x": 2}]}`,
+			opts:         Options{Matchers: true, Subset: true, SubsetLists: true},
+			wantResolved: `{"list": [{"name": "b"}, 1]}`,
+			wantActual:   `{"list": [{"name": "b"}, 1]}`,
+		},
+		{
+			name:     "Subset lists with missing element",
+			expected: `[1, 4]`,
+			actual:   `[3, 2, 1]`,
+			opts:     Options{Subset: true, SubsetLists: true},
+			wantMismatches: []types.Mismatch{
+				{Kind: types.MismatchRemoved, Path: "$[1]", Message: "no element in actual matches 4"},
+			},
+			wantActual: `[1]`,
+		},
+		{
+			name:       "Unordered lists",
+			expected:   `{"list": [3, 1, 2, 1]}`,
+			actual:     `{"list": [1, 2, 1, 3]}`,
+			opts:       Options{UnorderedLists: true},
+			wantActual: `{"list": [3, 1, 2, 1]}`,
+		},
+		{
+			name:     "Unordered lists with different counts",
+			expected: `[1, 1, 2]`,
+			actual:   `[2, 1, 3]`,
+			opts:     Options{UnorderedLists: true},
+			wantMismatches: []types.Mismatch{
+				{Kind: types.MismatchRemoved, Path: "$[1]", Message: "no element in actual matches 1"},
+				{Kind: types.MismatchAdded, Path: "$[2]", Message: "unexpected element 3"},
+			},
+			wantActual: `[1, 2, 3]`,
+		},
+		{
+			name:         "Unordered lists with overlapping matchers",
+			expected:     `[{"$type": "string"}, "a"]`,
+			actual:       `["a", "b"]`,
+			opts:         Options{Matchers: true, UnorderedLists: true},
+			wantResolved: `["b", "a"]`,
+			wantActual:   `["b", "a"]`,
+		},
+		{
+			name:         "Subset lists with overlapping tolerance",
+			expected:     `[1.0, 0.97]`,
+			actual:       `[0.99, 5, 1.01]`,
+			opts:         Options{Subset: true, SubsetLists: true, Tolerance: Tolerance{Absolute: 0.025}},
+			wantResolved: `[1.01, 0.99]`,
+			wantActual:   `[1.01, 0.99]`,
+		},
+		{
+			name:         "String options",
+			expected:     `{"ws": "a  b\n c ", "case": "Hello", "nl": "text\n\n", "all": " FOO\tbar\n"}`,
+			actual:       `{"ws": "a b c", "case": "hELLO", "nl": "text", "all": "foo BAR"}`,
+			opts:         Options{IgnoreWhitespace: true, IgnoreCase: true, IgnoreTrailingNewline: true},
+			wantResolved: `{"ws": "a b c", "case": "hELLO", "nl": "text", "all": "foo BAR"}`,
+		},
+		{
+			name:     "String options only apply when enabled",
+			expected: `["a  b", "Hello", "text\n"]`,
+			actual:   `["a b", "hello", "text"]`,
+			opts:     Options{IgnoreTrailingNewline: true},
+			wantMismatches: []types.Mismatch{
+				{Path: "$[0]", Message: `expected "a  b", got "a b"`},
+				{Path: "$[1]", Message: `expected "Hello", got "hello"`},
+			},
+			wantResolved: `["a  b", "Hello", "text"]`,
+		},
+		{
+			name:         "Tolerance",
+			expected:     `{"abs": 0.5, "rel": 1000, "list": [0.1, 0.2]}`,
+			actual:       `{"abs": 0.505, "rel": 1000.5, "list": [0.1000001, 0.3]}`,
+			opts:         Options{Tolerance: Tolerance{Absolute: 0.01, Relative: 0.001}},
+			wantResolved: `{"abs": 0.505, "rel": 1000.5, "list": [0.1000001, 0.2]}`,
+			wantMismatches: []types.Mismatch{
+				{Path: "$.list[1]", Message: "expected 0.2, got 0.3 (difference 0.1 exceeds the tolerance)"},
+			},
+		},
+		{
+			name:     "Matchers disabled",
+			expected: `{"a": {"$regex": "^v"}, "b": {"$any": true}}`,
+			actual:   `{"a": "v1", "b": {"$any": true}}`,
+			wantMismatches: []types.Mismatch{
+				{Path: "$.a", Message: `type mismatch: expected set {"$regex":"^v"}, got string "v1"`},
+			},
+		},
+		{
+			name:         "Escaped matcher keys",
+			expected:     `{"a": {"$$regex": "^v"}, "b": {"$contains": {"$$any": true}}}`,
+			actual:       `{"a": {"$regex": "^v"}, "b": [{"$any": true}]}`,
+			opts:         Options{Matchers: true},
+			wantResolved: `{"a": {"$regex": "^v"}, "b": [{"$any": true}]}`,
+		},
+		{name: "Invalid regex", expected: `{"$regex": "("}`, actual: `"a"`, opts: Options{Matchers: true}, wantErr: true},
+		{name: "Unknown type", expected: `{"$type": "foo"}`, actual: `"a"`, opts: Options{Matchers: true}, wantErr: true},
+		{name: "Unknown key", expected: `{"$regex": "a", "foo": 1}`, actual: `"a"`, opts: Options{Matchers: true}, wantErr: true},
+		{name: "Multiple matchers", expected: `{"$regex": "a", "$any": true}`, actual: `"a"`, opts: Options{Matchers: true}, wantErr: true},
+		{name: "Tolerance without approx", expected: `{"$any": true, "$tol": 1}`, actual: `"a"`, opts: Options{Matchers: true}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := Compare(decode(t, tt.expected), decode(t, tt.actual), tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Compare() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(result.Mismatches, tt.wantMismatches) {
+				t.Errorf("Compare() mismatches = %#v, want %#v", result.Mismatches, tt.wantMismatches)
+			}
+			wantResolved := tt.wantResolved
+			if wantResolved == "" {
+				wantResolved = tt.expected
+			}
+			if !reflect.DeepEqual(result.Expected, decode(t, wantResolved)) {
+				t.Errorf("Compare() expected = %#v, want %s", result.Expected, wantResolved)
+			}
+			wantActual := tt.wantActual
+			if wantActual == "" {
+				wantActual = tt.actual
+			}
+			if !reflect.DeepEqual(result.Actual, decode(t, wantActual)) {
+				t.Errorf("Compare() actual = %#v, want %s", result.Actual, wantActual)
+			}
+		})
+	}
+}
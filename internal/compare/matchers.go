@@ -0,0 +1,238 @@
+package compare
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"regexp"
+	"strings"
+
+	"gitlab.com/TECHNOFAB/nixtest/internal/types"
+)
+
+// Matchers are objects in expected which check actual instead of being
+// compared literally:
+//
+//	{"$regex": "^v\\d+"}          actual is a string matching the regex
+//	{"$contains": x}              actual is a string containing x or a list with an element matching x
+//	{"$type": "string"}           actual has the type (string, int, float, number, bool, null, list or set)
+//	{"$any": true}                actual can be anything
+//	{"$approx": 1.0, "$tol": 0.1} actual is a number within $tol (default 1e-9) of 1.0
+//
+// Keys of objects which should start with a literal "$" are escaped by
+// doubling it, {"$$regex": "a"} matches the object {"$regex": "a"}.
+const (
+	matchRegex    = "$regex"
+	matchContains = "$contains"
+	matchType     = "$type"
+	matchAny      = "$any"
+	matchApprox   = "$approx"
+	matchTol      = "$tol"
+
+	defaultTolerance = 1e-9
+)
+
+var matcherKeys = []string{matchRegex, matchContains, matchType, matchAny, matchApprox}
+
+// asMatcher returns the value as matcher if it is an object with a matcher key
+func asMatcher(value any) (map[string]any, bool) {
+	m, ok := value.(map[string]any)
+	if !ok {
+		return nil, false
+	}
+	for _, key := range matcherKeys {
+		if _, found := m[key]; found {
+			return m, true
+		}
+	}
+	return nil, false
+}
+
+// unescapeKeys replaces the escaped "$$" prefix of keys by "$"
+func unescapeKeys(m map[string]any) map[string]any {
+	escaped := false
+	for key := range m {
+		if strings.HasPrefix(key, "$$") {
+			escaped = true
+			break
+		}
+	}
+	if !escaped {
+		return m
+	}
+	result := make(map[string]any, len(m))
+	for key, value := range m {
+		if strings.HasPrefix(key, "$$") {
+			key = key[1:]
+		}
+		result[key] = value
+	}
+	return result
+}
+
+// compareMatcher checks actual against the matcher m, returning actual as
+// resolved value if it matches and the matcher itself otherwise
+func (c *comparer) compareMatcher(path string, m map[string]any, actual any) (any, error) {
+	kind, err := matcherKind(m)
+	if err != nil {
+		return nil, fmt.Errorf("invalid matcher at %s: %w", path, err)
+	}
+	arg := m[kind]
+
+	var message string
+	switch kind {
+	case matchAny:
+		// matches everything
+	case matchRegex:
+		pattern, ok := arg.(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid matcher at %s: %s needs a string", path, kind)
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid matcher at %s: %w", path, err)
+		}
+		if str, ok := actual.(string); !ok {
+			message = fmt.Sprintf("expected a string matching regex %q, got %s", pattern, formatValue(actual))
+		} else if !re.MatchString(str) {
+			message = fmt.Sprintf("%s does not match regex %q", formatValue(actual), pattern)
+		}
+	case matchContains:
+		switch act := actual.(type) {
+		case string:
+			sub, ok := arg.(string)
+			if !ok {
+				message = fmt.Sprintf("%s can only contain strings, got %s", kind, formatValue(arg))
+			} else if !strings.Contains(act, sub) {
+				message = fmt.Sprintf("%s does not contain %s", formatValue(actual), formatValue(arg))
+			}
+		case []any:
+			found := false
+			for _, elem := range act {
+				ok, _, _, err := c.matches(arg, elem)
+				if err != nil {
+					return nil, fmt.Errorf("invalid matcher at %s: %w", path, err)
+				}
+				if ok {
+					found = true
+					break
+				}
+			}
+			if !found {
+				message = fmt.Sprintf("list does not contain an element matching %s", formatValue(arg))
+			}
+		default:
+			message = fmt.Sprintf("expected a string or list containing %s, got %s", formatValue(arg), formatValue(actual))
+		}
+	case matchType:
+		want, ok := arg.(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid matcher at %s: %s needs a string", path, kind)
+		}
+		want, err := normalizeTypeName(want)
+		if err != nil {
+			return nil, fmt.Errorf("invalid matcher at %s: %w", path, err)
+		}
+		if !hasType(actual, want) {
+			message = fmt.Sprintf("expected type %s, got %s (%s)", want, typeName(actual), formatValue(actual))
+		}
+	case matchApprox:
+		want, ok := toFloat(arg)
+		if !ok {
+			return nil, fmt.Errorf("invalid matcher at %s: %s needs a number", path, kind)
+		}
+		tol := defaultTolerance
+		if rawTol, found := m[matchTol]; found {
+			if tol, ok = toFloat(rawTol); !ok || tol < 0 {
+				return nil, fmt.Errorf("invalid matcher at %s: %s needs a non-negative number", path, matchTol)
+			}
+		}
+		if got, ok := toFloat(actual); !ok {
+			message = fmt.Sprintf("expected a number close to %v, got %s", want, formatValue(actual))
+		} else if math.Abs(got-want) > tol {
+			message = fmt.Sprintf("%v is not within %v of %v", got, tol, want)
+		}
+	}
+
+	if message != "" {
+		c.mismatch(types.MismatchChanged, path, "%s failed: %s", kind, message)
+		return m, nil
+	}
+	return actual, nil
+}
+
+// matcherKind validates the keys of a matcher and returns its kind
+func matcherKind(m map[string]any) (string, error) {
+	kind := ""
+	for key := range m {
+		switch key {
+		case matchRegex, matchContains, matchType, matchAny, matchApprox:
+			if kind != "" {
+				return "", fmt.Errorf("only one of %s is allowed, got %s and %s", strings.Join(matcherKeys, ", "), kind, key)
+			}
+			kind = key
+		case matchTol:
+			if _, found := m[matchApprox]; !found {
+				return "", fmt.Errorf("%s can only be used with %s", matchTol, matchApprox)
+			}
+		default:
+			return "", fmt.Errorf("unknown key %q in matcher", key)
+		}
+	}
+	return kind, nil
+}
+
+func normalizeTypeName(name string) (string, error) {
+	switch name {
+	case "string", "int", "float", "number", "bool", "null", "list", "set":
+		return name, nil
+	case "boolean":
+		return "bool", nil
+	case "array":
+		return "list", nil
+	case "object", "attrs":
+		return "set", nil
+	default:
+		return "", fmt.Errorf("unknown type %q", name)
+	}
+}
+
+func hasType(value any, want string) bool {
+	got := typeName(value)
+	switch want {
+	case "number", "float":
+		// JSON doesn't distinguish 1.0 from 1, so every number is a float
+		return got == "int" || got == "float"
+	default:
+		return got == want
+	}
+}
+
+// typeName returns the Nix type name of a decoded JSON value
+func typeName(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "bool"
+	case float64:
+		if v == math.Trunc(v) {
+			return "int"
+		}
+		return "float"
+	case json.Number:
+		if _, ok := new(big.Int).SetString(v.String(), 10); ok {
+			return "int"
+		}
+		return "float"
+	case []any:
+		return "list"
+	case map[string]any:
+		return "set"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
@@ -18,6 +18,17 @@ type AppConfig struct {
 	SkipPattern     string
 	ImpureEnv       bool
 	NoColor         bool
+	KeepTmp         string
+	ArtifactsDir    string
+	Stats           bool
+	OutputLimit     int
+	OutputSpillDir  string
+	Verbose         bool
+	DiffStyle       string
+	DiffContext     int
+	MaxDiffLines    int
+	// Args are the positional arguments, e.g. `debug <suite>/<test>`
+	Args []string
 }
 
 // loads configuration from cli flags
@@ -31,20 +42,50 @@ func Load() AppConfig {
 	flag.StringVarP(&cfg.SkipPattern, "skip", "s", "", "Regular expression to skip tests (e.g., 'test-.*|.*-b')")
 	flag.BoolVar(&cfg.ImpureEnv, "impure", false, "Don't unset all env vars before running script tests")
 	flag.BoolVar(&cfg.NoColor, "no-color", false, "Disable coloring")
+	flag.StringVar(&cfg.KeepTmp, "keep-tmp", "never", "Keep working and artifacts directories of script and VM tests (never, failed or always)")
+	flag.Lookup("keep-tmp").NoOptDefVal = "failed"
+	flag.StringVar(&cfg.ArtifactsDir, "artifacts-dir", "", "Directory to collect script test artifacts ($NIXTEST_ARTIFACTS) to, leave empty to disable")
+	flag.IntVar(&cfg.OutputLimit, "output-limit", 1024*1024, "Maximum bytes of stdout/stderr to keep per script test (head and tail), 0 to disable")
+	flag.StringVar(&cfg.OutputSpillDir, "output-spill-dir", "", "Directory to write the full output of truncated script tests to, leave empty to disable")
+	flag.BoolVar(&cfg.Stats, "stats", false, "Show CPU time and peak memory usage of script tests in the summary")
+	flag.BoolVarP(&cfg.Verbose, "verbose", "v", false, "Stream output of script tests and nix builds live (enabled automatically for a single test)")
+	flag.StringVar(&cfg.DiffStyle, "diff-style", "structural", "How to show differences of failed tests (structural, unified or side-by-side)")
+	flag.IntVar(&cfg.DiffContext, "diff-context", 3, "Amount of unchanged lines shown around changes in diffs")
+	flag.IntVar(&cfg.MaxDiffLines, "max-diff-lines", 200, "Maximum lines shown per failed test diff, the rest is summarized, 0 to disable")
 	helpRequested := flag.BoolP("help", "h", false, "Show this menu")
 
 	flag.Parse()
 
 	if *helpRequested {
 		fmt.Println("Usage of nixtest:")
+		fmt.Println("  nixtest [flags]                         Run all tests")
+		fmt.Println("  nixtest [flags] debug <suite>/<test>    Open a shell in the prepared working directory of a test")
+		fmt.Println("Flags:")
 		flag.PrintDefaults()
 		os.Exit(0)
 	}
 
+	cfg.Args = flag.Args()
+
+	// --keep-tmp has an optional value, so `--keep-tmp always` ends up as
+	// --keep-tmp=failed with `always` as a positional argument
+	if flag.Lookup("keep-tmp").Changed && len(cfg.Args) > 0 {
+		switch cfg.Args[0] {
+		case "never", "failed", "always":
+			log.Panic().Str("arg", cfg.Args[0]).Msg("Unexpected argument, pass the mode as --keep-tmp=<mode>.")
+		}
+	}
+
 	if cfg.TestsFile == "" {
 		log.Panic().Msg("Tests file path (-f or --tests) is required.")
 	}
 
+	switch cfg.DiffStyle {
+	case "structural", "unified", "side-by-side":
+	default:
+		log.Panic().Str("diff-style", cfg.DiffStyle).Msg("Invalid diff style, must be one of structural, unified or side-by-side.")
+	}
+
 	if cfg.NoColor {
 		text.DisableColors()
 	}
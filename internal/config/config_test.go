@@ -28,6 +28,40 @@ func TestLoad_Defaults(t *testing.T) {
 	if cfg.SnapshotDir != "./snapshots" {
 		t.Errorf("Default SnapshotDir: got %s, want ./snapshots", cfg.SnapshotDir)
 	}
+	if cfg.KeepTmp != "never" {
+		t.Errorf("Default KeepTmp: got %s, want never", cfg.KeepTmp)
+	}
+	if cfg.DiffStyle != "structural" || cfg.DiffContext != 3 || cfg.MaxDiffLines != 200 {
+		t.Errorf("Default diff options: got %s/%d/%d, want structural/3/200", cfg.DiffStyle, cfg.DiffContext, cfg.MaxDiffLines)
+	}
+}
+
+func TestLoad_InvalidDiffStyle(t *testing.T) {
+	originalArgs := os.Args
+	oldFlagSet := pflag.CommandLine
+	defer func() {
+		os.Args = originalArgs
+		pflag.CommandLine = oldFlagSet
+	}()
+
+	os.Args = []string{"cmd", "-f", "dummy.json", "--diff-style", "fancy"}
+	pflag.CommandLine = pflag.NewFlagSet(os.Args[0], pflag.ExitOnError) // Reset flags
+
+	assert.Panics(t, func() { _ = Load() }, "Load should panic with an invalid diff style")
+}
+
+func TestLoad_KeepTmpWithoutEquals(t *testing.T) {
+	originalArgs := os.Args
+	oldFlagSet := pflag.CommandLine
+	defer func() {
+		os.Args = originalArgs
+		pflag.CommandLine = oldFlagSet
+	}()
+
+	os.Args = []string{"cmd", "-f", "dummy.json", "--keep-tmp", "always"}
+	pflag.CommandLine = pflag.NewFlagSet(os.Args[0], pflag.ExitOnError) // Reset flags
+
+	assert.Panics(t, func() { _ = Load() }, "Load should panic when the keep-tmp mode is passed as a separate argument")
 }
 
 func TestLoad_Fatal(t *testing.T) {
@@ -66,6 +100,10 @@ func TestLoad_CustomValues(t *testing.T) {
 		"--skip", "specific-test",
 		"--impure",
 		"--no-color",
+		"--diff-style", "side-by-side",
+		"--diff-context", "1",
+		"--keep-tmp",
+		"debug", "suite/test",
 	}
 	pflag.CommandLine = pflag.NewFlagSet(os.Args[0], pflag.ExitOnError) // Reset flags
 
@@ -86,4 +124,13 @@ func TestLoad_CustomValues(t *testing.T) {
 	if !cfg.ImpureEnv {
 		t.Errorf("ImpureEnv: got %v, want true", cfg.ImpureEnv)
 	}
+	if cfg.KeepTmp != "failed" {
+		t.Errorf("KeepTmp: got %s, want failed", cfg.KeepTmp)
+	}
+	if cfg.DiffStyle != "side-by-side" || cfg.DiffContext != 1 {
+		t.Errorf("Diff options: got %s/%d, want side-by-side/1", cfg.DiffStyle, cfg.DiffContext)
+	}
+	if len(cfg.Args) != 2 || cfg.Args[0] != "debug" || cfg.Args[1] != "suite/test" {
+		t.Errorf("Args: got %v, want [debug suite/test]", cfg.Args)
+	}
 }
@@ -16,6 +16,18 @@ func (e *NixBuildError) Error() string {
 }
 func (e *NixBuildError) Unwrap() error { return e.Err }
 
+// NixEvalError indicates an error during `nix eval`, Stderr contains the trace
+type NixEvalError struct {
+	Target string
+	Stderr string
+	Err    error
+}
+
+func (e *NixEvalError) Error() string {
+	return fmt.Sprintf("nix eval of %s failed: %v (stderr: %s)", e.Target, e.Err, e.Stderr)
+}
+func (e *NixEvalError) Unwrap() error { return e.Err }
+
 // NixNoOutputPathError indicates `nix build` succeeded but produced no output path
 type NixNoOutputPathError struct {
 	Derivation string
@@ -48,6 +60,18 @@ func (e *JSONUnmarshalError) Error() string {
 }
 func (e *JSONUnmarshalError) Unwrap() error { return e.Err }
 
+// ParseError indicates an error parsing data in a format other than JSON
+type ParseError struct {
+	Format string // e.g. "yaml"
+	Source string
+	Err    error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("failed to parse %s from %s: %v", e.Format, e.Source, e.Err)
+}
+func (e *ParseError) Unwrap() error { return e.Err }
+
 // ScriptExecutionError indicates an error starting or waiting for a script
 type ScriptExecutionError struct {
 	Path string // path to script that was attempted to run
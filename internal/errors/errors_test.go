@@ -66,6 +66,22 @@ func TestJSONUnmarshalError(t *testing.T) {
 	}
 }
 
+func TestParseError(t *testing.T) {
+	underlyingErr := errors.New("did not find expected key")
+	err := &ParseError{
+		Format: "yaml",
+		Source: "/nix/store/abc-config.yaml",
+		Err:    underlyingErr,
+	}
+	expectedMsg := "failed to parse yaml from /nix/store/abc-config.yaml: did not find expected key"
+	if err.Error() != expectedMsg {
+		t.Errorf("Error() got %q, want %q", err.Error(), expectedMsg)
+	}
+	if !errors.Is(err, underlyingErr) {
+		t.Errorf("Unwrap() failed, underlying error not found")
+	}
+}
+
 func TestScriptExecutionError(t *testing.T) {
 	underlyingErr := errors.New("command timed out")
 	err := &ScriptExecutionError{
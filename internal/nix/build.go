@@ -0,0 +1,43 @@
+package nix
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gitlab.com/TECHNOFAB/nixtest/internal/util"
+)
+
+// failedBuilderRegexes match the messages of nix build when a builder ran and
+// failed, older versions print the first, newer ones the second
+var failedBuilderRegexes = []*regexp.Regexp{
+	regexp.MustCompile(`builder for '(/nix/store/[^']+\.drv)' failed`),
+	regexp.MustCompile(`Cannot build '(/nix/store/[^']+\.drv)'\.\s+Reason: builder failed`),
+}
+
+// FailedBuilder returns the derivation whose builder failed according to the
+// stderr of a failed `nix build`. It returns false if nix couldn't build at
+// all, e.g. because the derivation is missing or the daemon is unreachable.
+func FailedBuilder(stderr string) (string, bool) {
+	stderr = util.StripANSI(stderr)
+	for _, re := range failedBuilderRegexes {
+		if match := re.FindStringSubmatch(stderr); match != nil {
+			return match[1], true
+		}
+	}
+	return "", false
+}
+
+// BuildLog returns the full build log of a derivation using `nix log`, nix
+// build itself only prints the last lines of failed builds
+func (s *DefaultService) BuildLog(derivation string) (string, error) {
+	cmd := s.commandExecutor("nix", "log", derivation)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("failed to get build log of %s: %w: %s", derivation, err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
@@ -0,0 +1,70 @@
+package nix
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestFailedBuilder(t *testing.T) {
+	tests := []struct {
+		name     string
+		stderr   string
+		wantDrv  string
+		wantFail bool
+	}{
+		{
+			name:     "Builder failed",
+			stderr:   "error: builder for '/nix/store/abc-broken.drv' failed with exit code 1;\n       last 2 log lines:\n       > error: boom\n",
+			wantDrv:  "/nix/store/abc-broken.drv",
+			wantFail: true,
+		},
+		{
+			name:     "Builder of dependency failed",
+			stderr:   "error: \x1b[1mCannot build '/nix/store/abc-dep.drv'.\x1b[0m\n       Reason: builder failed with exit code 2.\nerror: 1 dependencies of derivation '/nix/store/def-top.drv' failed to build\n",
+			wantDrv:  "/nix/store/abc-dep.drv",
+			wantFail: true,
+		},
+		{
+			name:   "Missing derivation",
+			stderr: "error: path '/nix/store/abc-missing.drv' is not valid\n",
+		},
+		{
+			name:   "Daemon unreachable",
+			stderr: "error: cannot connect to socket at '/nix/var/nix/daemon-socket/socket': Connection refused\n",
+		},
+		{
+			name:   "Unsupported system",
+			stderr: "error: Cannot build '/nix/store/abc-x.drv'.\n       Reason: required system or feature not available\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			drv, failed := FailedBuilder(tt.stderr)
+			if drv != tt.wantDrv || failed != tt.wantFail {
+				t.Errorf("FailedBuilder() = %q, %v, want %q, %v", drv, failed, tt.wantDrv, tt.wantFail)
+			}
+		})
+	}
+}
+
+func TestDefaultService_BuildLog(t *testing.T) {
+	service := NewDefaultService()
+	mockExecCommandForService(service)
+
+	os.Setenv("MOCK_NIX_LOG", "line 1\nline 2\n")
+	got, err := service.BuildLog("/nix/store/abc-broken.drv")
+	os.Unsetenv("MOCK_NIX_LOG")
+	if err != nil {
+		t.Fatalf("BuildLog() unexpected error = %v", err)
+	}
+	if got != "line 1\nline 2\n" {
+		t.Errorf("BuildLog() = %q, want %q", got, "line 1\nline 2\n")
+	}
+
+	_, err = service.BuildLog("/nix/store/abc-unknown.drv")
+	if err == nil || !strings.Contains(err.Error(), "build log of '/nix/store/abc-unknown.drv' is not available") {
+		t.Errorf("BuildLog() error = %v, want error about the missing log", err)
+	}
+}
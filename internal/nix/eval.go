@@ -0,0 +1,184 @@
+package nix
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	apperrors "gitlab.com/TECHNOFAB/nixtest/internal/errors"
+	"gitlab.com/TECHNOFAB/nixtest/internal/util"
+)
+
+// EvalTarget is what `nix eval` evaluates, an attribute of a flake or of a
+// Nix file (or the file itself if Attr is empty)
+type EvalTarget struct {
+	Flake string
+	File  string
+	Attr  string
+}
+
+func (t EvalTarget) String() string {
+	if t.File != "" {
+		if t.Attr == "" {
+			return t.File
+		}
+		return t.File + " " + t.Attr
+	}
+	return t.Flake + "#" + t.Attr
+}
+
+// args returns the arguments selecting the target for `nix eval`
+func (t EvalTarget) args() ([]string, error) {
+	switch {
+	case t.File != "" && t.Flake != "":
+		return nil, fmt.Errorf("only one of flake or file can be evaluated")
+	case t.File != "":
+		args := []string{"--file", t.File}
+		if t.Attr != "" {
+			args = append(args, t.Attr)
+		}
+		return args, nil
+	case t.Flake != "" && t.Attr != "":
+		return []string{t.Flake + "#" + t.Attr}, nil
+	default:
+		return nil, fmt.Errorf("either a flake and attribute or a file to evaluate is required")
+	}
+}
+
+// Eval evaluates the target with `nix eval --json`, numbers are decoded as
+// json.Number. Evaluation errors are returned as NixEvalError including the trace.
+func (s *DefaultService) Eval(target EvalTarget) (any, error) {
+	targetArgs, err := target.args()
+	if err != nil {
+		return nil, err
+	}
+	args := append([]string{"eval", "--json", "--show-trace"}, targetArgs...)
+	cmd := s.commandExecutor("nix", args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		// only nix reporting an error is an evaluation failure, nix not starting
+		// or being killed says nothing about the evaluated code
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && hasErrorMessage(stderr.String()) {
+			return nil, &apperrors.NixEvalError{Target: target.String(), Stderr: stderr.String(), Err: err}
+		}
+		if output := strings.TrimSpace(stderr.String()); output != "" {
+			return nil, fmt.Errorf("failed to run nix eval for %s: %w: %s", target, err, output)
+		}
+		return nil, fmt.Errorf("failed to run nix eval for %s: %w", target, err)
+	}
+
+	var result any
+	decoder := json.NewDecoder(&stdout)
+	decoder.UseNumber()
+	if err := decoder.Decode(&result); err != nil {
+		return nil, &apperrors.JSONUnmarshalError{Source: "nix eval " + target.String(), Err: err}
+	}
+	return result, nil
+}
+
+// unresolvedMessages match the messages nix itself uses when it can't find or
+// fetch what it should evaluate, they're anchored so messages of the evaluated
+// code merely containing the same words don't match
+var unresolvedMessages = []*regexp.Regexp{
+	regexp.MustCompile(`^attribute '[^']*' in selection path '[^']*' not found`),
+	regexp.MustCompile(`^flake '[^']*' does not provide attribute`),
+	regexp.MustCompile(`^cannot find flake '`),
+	regexp.MustCompile(`^unable to download '`),
+	regexp.MustCompile(`^lock file contains `),
+	regexp.MustCompile(`^cannot write modified lock file`),
+}
+
+// userErrorFrames are trace lines showing that the error was raised by the
+// evaluated code itself
+var userErrorFrames = []string{
+	"while calling the 'throw' builtin",
+	"while calling the 'abort' builtin",
+}
+
+// TargetUnresolved reports whether the stderr of a failed evaluation means
+// that the target couldn't be resolved at all: a missing attribute of its
+// attribute path, a missing file or a flake which can't be found, fetched or
+// locked. Such failures aren't what throws tests check for. Errors raised by
+// throw or abort never count, whatever their message is.
+func TargetUnresolved(target EvalTarget, stderr string) bool {
+	stderr = util.StripANSI(stderr)
+	for _, frame := range userErrorFrames {
+		if strings.Contains(stderr, frame) {
+			return false
+		}
+	}
+
+	message := EvalErrorMessage(stderr)
+	for _, re := range unresolvedMessages {
+		if re.MatchString(message) {
+			return true
+		}
+	}
+	if target.File != "" &&
+		(strings.HasPrefix(message, "path '"+target.File+"' does not exist") ||
+			strings.HasPrefix(message, "getting status of '"+target.File+"'")) {
+		return true
+	}
+	for _, name := range strings.Split(target.Attr, ".") {
+		if name != "" && message == "attribute '"+name+"' missing" {
+			return true
+		}
+	}
+	return false
+}
+
+// hasErrorMessage reports whether the stderr of nix contains an error message
+func hasErrorMessage(stderr string) bool {
+	for _, line := range strings.Split(util.StripANSI(stderr), "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "error:") {
+			return true
+		}
+	}
+	return false
+}
+
+// codeFrame matches the source excerpt lines nix prints below a location
+var codeFrame = regexp.MustCompile(`^\s*\d*\|`)
+
+// EvalErrorMessage extracts the error message from the stderr of a failed
+// `nix eval`, without the trace, locations and source excerpts. Nix prints the
+// innermost error (the message of throw, abort, assert etc.) last.
+func EvalErrorMessage(stderr string) string {
+	lines := strings.Split(util.StripANSI(stderr), "\n")
+
+	start := -1
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "error:") && trimmed != "error:" {
+			start = i
+		}
+	}
+	if start == -1 {
+		return strings.TrimSpace(stderr)
+	}
+
+	first := lines[start]
+	indent := len(first) - len(strings.TrimLeft(first, " "))
+	message := []string{strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(first), "error:"))}
+	for _, line := range lines[start+1:] {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" ||
+			strings.HasPrefix(trimmed, "at ") ||
+			strings.HasPrefix(trimmed, "…") ||
+			strings.HasPrefix(trimmed, "(use '--show-trace'") ||
+			codeFrame.MatchString(line) {
+			break
+		}
+		// nix indents further lines like the error line, keep anything beyond that
+		message = append(message, strings.TrimRight(line[min(indent, len(line)-len(strings.TrimLeft(line, " "))):], " "))
+	}
+	return strings.Join(message, "\n")
+}
@@ -0,0 +1,107 @@
+package nix
+
+import "testing"
+
+func TestEvalErrorMessage(t *testing.T) {
+	tests := []struct {
+		name   string
+		stderr string
+		want   string
+	}{
+		{
+			name: "Throw with trace",
+			stderr: `error:
+       … while evaluating the attribute 'result'
+         at /src/tests.nix:3:3:
+            2| {
+            3|   result = parse "1.x";
+             |   ^
+            4| }
+
+       … while calling the 'throw' builtin
+         at /src/parse.nix:5:7:
+            4|     then v
+            5|     else throw "invalid version: ${v}";
+             |       ^
+            6| }
+
+       error: invalid version: 1.x
+`,
+			want: "invalid version: 1.x",
+		},
+		{
+			name: "Failed assertion",
+			stderr: "error:\n       … while evaluating the attribute 'x'\n\n" +
+				"       error: assertion '(n > 0)' failed\n" +
+				"       at /src/tests.nix:2:7:\n" +
+				"            1| n:\n" +
+				"            2|   assert n > 0; n\n",
+			want: "assertion '(n > 0)' failed",
+		},
+		{
+			name:   "Without trace",
+			stderr: "error: boom\n(use '--show-trace' to show detailed location information)\n",
+			want:   "boom",
+		},
+		{
+			name:   "Multi-line message",
+			stderr: "error:\n       … while calling the 'throw' builtin\n\n       error: first line\n         indented\n       second line\n",
+			want:   "first line\n  indented\nsecond line",
+		},
+		{
+			name:   "Colored output",
+			stderr: "\x1b[31;1merror:\x1b[0m\n       \x1b[31;1merror:\x1b[0m boom\n",
+			want:   "boom",
+		},
+		{
+			name:   "Warnings before the error",
+			stderr: "warning: Git tree '/src' is dirty\nerror: attribute 'missing' missing\n",
+			want:   "attribute 'missing' missing",
+		},
+		{
+			name:   "No error line",
+			stderr: "  something unexpected\n",
+			want:   "something unexpected",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := EvalErrorMessage(tt.stderr); got != tt.want {
+				t.Errorf("EvalErrorMessage() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTargetUnresolved(t *testing.T) {
+	fileTarget := EvalTarget{File: "/src/tests.nix", Attr: "lib.parse"}
+	flakeTarget := EvalTarget{Flake: ".", Attr: "lib.parse"}
+	throwTrace := "error:\n       … while calling the 'throw' builtin\n         at /src/lib.nix:3:5:\n\n       error: "
+	tests := []struct {
+		name   string
+		target EvalTarget
+		stderr string
+		want   bool
+	}{
+		{"Throw", fileTarget, throwTrace + "invalid version: 1.x\n", false},
+		{"Failed assertion", fileTarget, "error: assertion '(n > 0)' failed\n", false},
+		{"Missing attribute in the evaluated code", fileTarget, "error: attribute 'major' missing\n", false},
+		{"Typo in the attribute path", fileTarget, "error: attribute 'parse' missing\n", true},
+		{"Selection path", fileTarget, "error: attribute 'pasre' in selection path 'lib.pasre' not found\n", true},
+		{"Missing file", fileTarget, "error: path '/src/tests.nix' does not exist\n", true},
+		{"Missing file imported by the code", fileTarget, "error: path '/src/other.nix' does not exist\n", false},
+		{"Flake attribute", flakeTarget, "error: flake 'path:/src' does not provide attribute 'packages.x86_64-linux.lib.pasre', 'legacyPackages.x86_64-linux.lib.pasre' or 'lib.pasre'\n", true},
+		{"Flake fetch", flakeTarget, "error:\n       … while fetching the input 'github:NixOS/nixpkgs'\n\n       error: unable to download 'https://github.com/NixOS/nixpkgs/archive/abc.tar.gz': Couldn't resolve host name\n", true},
+		{"Lock file", flakeTarget, "error: lock file contains unlocked input 'path:/src/dep'\n", true},
+		{"Throw mentioning a download", flakeTarget, throwTrace + "unable to download 'https://example.com/data.json'\n", false},
+		{"Throw mentioning the lock file", fileTarget, "error: invalid lock file entry: foo\n", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := TargetUnresolved(tt.target, tt.stderr); got != tt.want {
+				t.Errorf("TargetUnresolved() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package nix
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"gitlab.com/TECHNOFAB/nixtest/internal/types"
+)
+
+// exit codes of shells when a child got killed by these signals
+const (
+	exitCodeSIGKILL = 128 + int(syscall.SIGKILL)
+	exitCodeSIGXCPU = 128 + int(syscall.SIGXCPU)
+)
+
+// memoryLimitThreshold is the share of the memory limit above which a failed
+// script's peak RSS counts as having hit the limit. RLIMIT_AS limits virtual
+// memory, so the RSS never quite reaches it.
+const memoryLimitThreshold = 0.9
+
+// memoryErrorHints are parts of the messages programs print when allocations
+// fail with ENOMEM, lowercased
+var memoryErrorHints = []string{"cannot allocate memory", "out of memory", "memoryerror", "bad_alloc", "memory exhausted"}
+
+// limitsCommand wraps the command so the resource limits get applied with
+// ulimit before exec'ing it, which prevents any race with the script starting
+func limitsCommand(cmdArgs []string, limits types.ResourceLimits) []string {
+	var ulimits []string
+	if limits.Memory > 0 {
+		// ulimit -v takes KiB
+		ulimits = append(ulimits, fmt.Sprintf("ulimit -v %d", (limits.Memory+1023)/1024))
+	}
+	if limits.CPUTime > 0 {
+		ulimits = append(ulimits, fmt.Sprintf("ulimit -t %d", limits.CPUTime))
+	}
+	if limits.OpenFiles > 0 {
+		ulimits = append(ulimits, fmt.Sprintf("ulimit -n %d", limits.OpenFiles))
+	}
+	if len(ulimits) == 0 {
+		return cmdArgs
+	}
+	script := strings.Join(ulimits, " && ") + ` && exec "$@"`
+	return append([]string{"bash", "-c", script, "nixtest-limits"}, cmdArgs...)
+}
+
+// processUsage returns the peak RSS in bytes and the used CPU time of a finished process
+func processUsage(state *os.ProcessState) (peakRSS int64, cpuTime time.Duration) {
+	if state == nil {
+		return 0, 0
+	}
+	cpuTime = state.UserTime() + state.SystemTime()
+	if rusage, ok := state.SysUsage().(*syscall.Rusage); ok {
+		peakRSS = int64(rusage.Maxrss)
+		// Linux reports KiB, macOS bytes
+		if runtime.GOOS != "darwin" {
+			peakRSS *= 1024
+		}
+	}
+	return peakRSS, cpuTime
+}
+
+// exitSignal returns the signal which killed a finished process, if any
+func exitSignal(state *os.ProcessState) (syscall.Signal, bool) {
+	if state == nil {
+		return 0, false
+	}
+	status, ok := state.Sys().(syscall.WaitStatus)
+	if !ok || !status.Signaled() {
+		return 0, false
+	}
+	return status.Signal(), true
+}
+
+// limitExceeded tries to figure out if a failed script exceeded one of its
+// limits. The kernel signals exceeding RLIMIT_CPU, but exceeding RLIMIT_AS or
+// RLIMIT_NOFILE only makes allocations or open(2) fail, how the program
+// handles that is up to it. Memory is thus only blamed if the program
+// reported ENOMEM or its peak RSS got close to the limit, a crash alone
+// (SIGSEGV, SIGABRT, SIGKILL) could have any cause.
+func limitExceeded(limits types.ResourceLimits, state *os.ProcessState, exitCode int, peakRSS int64, cpuTime time.Duration, stderr string) string {
+	signal, _ := exitSignal(state)
+	killedBy := func(sig syscall.Signal, code int) bool {
+		return signal == sig || exitCode == code
+	}
+	lowerStderr := strings.ToLower(stderr)
+
+	if limits.CPUTime > 0 {
+		limit := time.Duration(limits.CPUTime) * time.Second
+		if killedBy(syscall.SIGXCPU, exitCodeSIGXCPU) ||
+			(killedBy(syscall.SIGKILL, exitCodeSIGKILL) && cpuTime >= limit) {
+			return fmt.Sprintf("CPU time limit of %s exceeded", limit)
+		}
+	}
+	if limits.Memory > 0 {
+		nearLimit := float64(peakRSS) >= memoryLimitThreshold*float64(limits.Memory)
+		if nearLimit || slices.ContainsFunc(memoryErrorHints, func(hint string) bool { return strings.Contains(lowerStderr, hint) }) {
+			return fmt.Sprintf("memory limit of %d bytes exceeded (peak RSS %d bytes)", limits.Memory, peakRSS)
+		}
+	}
+	if limits.OpenFiles > 0 && strings.Contains(lowerStderr, "too many open files") {
+		return fmt.Sprintf("open files limit of %d exceeded", limits.OpenFiles)
+	}
+	return ""
+}
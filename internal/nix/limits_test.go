@@ -0,0 +1,91 @@
+package nix
+
+import (
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"gitlab.com/TECHNOFAB/nixtest/internal/types"
+)
+
+func TestLimitsCommand(t *testing.T) {
+	cmdArgs := []string{"bash", "/nix/store/script"}
+
+	if got := limitsCommand(cmdArgs, types.ResourceLimits{}); !reflect.DeepEqual(got, cmdArgs) {
+		t.Errorf("limitsCommand() without limits = %v, want %v", got, cmdArgs)
+	}
+
+	got := limitsCommand(cmdArgs, types.ResourceLimits{Memory: 1024*1024 + 1, CPUTime: 10, OpenFiles: 64})
+	want := []string{
+		"bash", "-c", `ulimit -v 1025 && ulimit -t 10 && ulimit -n 64 && exec "$@"`, "nixtest-limits",
+		"bash", "/nix/store/script",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("limitsCommand() = %q, want %q", got, want)
+	}
+}
+
+func TestLimitExceeded(t *testing.T) {
+	tests := []struct {
+		name     string
+		limits   types.ResourceLimits
+		exitCode int
+		peakRSS  int64
+		cpuTime  time.Duration
+		stderr   string
+		want     string
+	}{
+		{"No limits", types.ResourceLimits{}, exitCodeSIGKILL, 0, 0, "out of memory", ""},
+		{"CPU via shell exit code", types.ResourceLimits{CPUTime: 2}, exitCodeSIGXCPU, 0, 0, "", "CPU time limit of 2s exceeded"},
+		{"CPU killed after limit", types.ResourceLimits{CPUTime: 2}, exitCodeSIGKILL, 0, 3 * time.Second, "", "CPU time limit of 2s exceeded"},
+		{"Killed before CPU limit", types.ResourceLimits{CPUTime: 2}, exitCodeSIGKILL, 0, time.Second, "", ""},
+		{"Memory allocation failure", types.ResourceLimits{Memory: 1024}, 1, 100, 0, "python: MemoryError", "memory limit of 1024 bytes exceeded (peak RSS 100 bytes)"},
+		{"Memory abort after ENOMEM", types.ResourceLimits{Memory: 1024}, 128 + int(syscall.SIGABRT), 100, 0, "terminate called after throwing an instance of 'std::bad_alloc'", "memory limit of 1024 bytes exceeded"},
+		{"Memory peak RSS near limit", types.ResourceLimits{Memory: 1000}, 1, 950, 0, "", "memory limit of 1000 bytes exceeded (peak RSS 950 bytes)"},
+		{"Segfault under memory limit", types.ResourceLimits{Memory: 1 << 30}, 128 + int(syscall.SIGSEGV), 4096, 0, "Segmentation fault", ""},
+		{"Killed under memory limit", types.ResourceLimits{Memory: 1 << 30}, exitCodeSIGKILL, 4096, 0, "", ""},
+		{"Open files", types.ResourceLimits{OpenFiles: 8}, 1, 0, 0, "open: Too many open files", "open files limit of 8 exceeded"},
+		{"Unrelated failure", types.ResourceLimits{Memory: 1024, OpenFiles: 8}, 1, 100, 0, "assertion failed", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := limitExceeded(tt.limits, nil, tt.exitCode, tt.peakRSS, tt.cpuTime, tt.stderr)
+			if !strings.HasPrefix(got, tt.want) || (tt.want == "") != (got == "") {
+				t.Errorf("limitExceeded() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProcessUsage(t *testing.T) {
+	if rss, cpu := processUsage(nil); rss != 0 || cpu != 0 {
+		t.Errorf("processUsage(nil) = %d, %s, want 0, 0", rss, cpu)
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("failed to run process: %v", err)
+	}
+	rss, _ := processUsage(cmd.ProcessState)
+	if rss <= 0 {
+		t.Errorf("processUsage() peakRSS = %d, want > 0", rss)
+	}
+}
+
+func TestLimitsCommand_RealShell(t *testing.T) {
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("bash not available")
+	}
+	args := limitsCommand([]string{"bash", "-c", "ulimit -n"}, types.ResourceLimits{OpenFiles: 42})
+	out, err := exec.Command(args[0], args[1:]...).Output()
+	if err != nil {
+		t.Fatalf("failed to run limited command: %v", err)
+	}
+	if got := strings.TrimSpace(string(out)); got != "42" {
+		t.Errorf("open files limit = %q, want 42", got)
+	}
+}
@@ -0,0 +1,139 @@
+package nix
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	apperrors "gitlab.com/TECHNOFAB/nixtest/internal/errors"
+	"gitlab.com/TECHNOFAB/nixtest/internal/types"
+	"gopkg.in/yaml.v3"
+)
+
+// parseOutput parses the output of a derivation in the given format. Structured
+// formats are normalized to the values JSON decodes to (numbers as json.Number,
+// objects as map[string]any and arrays as []any), so they can be compared
+// and diffed like JSON. Text is returned as is.
+func parseOutput(data []byte, format types.Format, source string) (any, error) {
+	switch format {
+	case "", types.FormatJSON:
+		var result any
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		err := decoder.Decode(&result)
+		if err == nil && decoder.More() {
+			err = fmt.Errorf("invalid character after top-level value")
+		}
+		if err != nil {
+			return nil, &apperrors.JSONUnmarshalError{Source: source, Err: err}
+		}
+		return result, nil
+	case types.FormatText:
+		return string(data), nil
+	case types.FormatYAML:
+		var result any
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		err := decoder.Decode(&result)
+		if err == nil {
+			var next any
+			if nextErr := decoder.Decode(&next); nextErr == nil {
+				err = fmt.Errorf("multiple YAML documents, only one is supported")
+			} else if nextErr != io.EOF {
+				err = nextErr
+			}
+		} else if err == io.EOF {
+			// empty documents are null, like with yaml.Unmarshal
+			err = nil
+		}
+		if err != nil {
+			return nil, &apperrors.ParseError{Format: string(format), Source: source, Err: err}
+		}
+		return normalizeParsed(format, source, result)
+	case types.FormatTOML:
+		var result map[string]any
+		if err := toml.Unmarshal(data, &result); err != nil {
+			return nil, &apperrors.ParseError{Format: string(format), Source: source, Err: err}
+		}
+		return normalizeParsed(format, source, result)
+	default:
+		return nil, fmt.Errorf("invalid actualFormat %q, must be one of %s, %s, %s or %s", format, types.FormatJSON, types.FormatText, types.FormatYAML, types.FormatTOML)
+	}
+}
+
+func normalizeParsed(format types.Format, source string, value any) (any, error) {
+	normalized, err := normalize(value)
+	if err != nil {
+		return nil, &apperrors.ParseError{Format: string(format), Source: source, Err: err}
+	}
+	return normalized, nil
+}
+
+// formatTime formats dates and times like they were written, TOML marks
+// local ones (without offset) with special time zones
+func formatTime(t time.Time) string {
+	switch t.Location().String() {
+	case "datetime-local":
+		return t.Format("2006-01-02T15:04:05.999999999")
+	case "date-local":
+		return t.Format(time.DateOnly)
+	case "time-local":
+		return t.Format("15:04:05.999999999")
+	default:
+		return t.Format(time.RFC3339Nano)
+	}
+}
+
+// normalize converts YAML and TOML values to JSON values. Keys which are not
+// strings are formatted, dates and times become strings.
+func normalize(value any) (any, error) {
+	switch v := value.(type) {
+	case nil, bool, string:
+		return v, nil
+	case int:
+		return json.Number(strconv.Itoa(v)), nil
+	case int64:
+		return json.Number(strconv.FormatInt(v, 10)), nil
+	case uint64:
+		return json.Number(strconv.FormatUint(v, 10)), nil
+	case float64:
+		if math.IsInf(v, 0) || math.IsNaN(v) {
+			return nil, fmt.Errorf("%v can't be represented in JSON", v)
+		}
+		return json.Number(strconv.FormatFloat(v, 'g', -1, 64)), nil
+	case time.Time:
+		return formatTime(v), nil
+	}
+
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Map:
+		result := make(map[string]any, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			child, err := normalize(iter.Value().Interface())
+			if err != nil {
+				return nil, err
+			}
+			result[fmt.Sprint(iter.Key().Interface())] = child
+		}
+		return result, nil
+	case reflect.Slice, reflect.Array:
+		result := make([]any, rv.Len())
+		for i := range result {
+			child, err := normalize(rv.Index(i).Interface())
+			if err != nil {
+				return nil, err
+			}
+			result[i] = child
+		}
+		return result, nil
+	default:
+		return nil, fmt.Errorf("unsupported value %v of type %T", value, value)
+	}
+}
@@ -0,0 +1,139 @@
+package nix
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gitlab.com/TECHNOFAB/nixtest/internal/types"
+)
+
+func TestParseOutput(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  types.Format
+		data    string
+		want    any
+		wantErr string
+	}{
+		{
+			name: "JSON by default",
+			data: `{"big": 9007199254740993}`,
+			want: map[string]any{"big": json.Number("9007199254740993")},
+		},
+		{
+			name:    "JSON with trailing data",
+			format:  types.FormatJSON,
+			data:    `{} {}`,
+			wantErr: "invalid character after top-level value",
+		},
+		{
+			name:   "Text",
+			format: types.FormatText,
+			data:   "line 1\nline 2\n",
+			want:   "line 1\nline 2\n",
+		},
+		{
+			name:   "YAML",
+			format: types.FormatYAML,
+			data: `name: app
+replicas: 3
+ratio: 0.5
+enabled: true
+tags: [a, b]
+empty: null
+1: numeric key
+created: 2024-01-02T03:04:05Z
+`,
+			want: map[string]any{
+				"name":     "app",
+				"replicas": json.Number("3"),
+				"ratio":    json.Number("0.5"),
+				"enabled":  true,
+				"tags":     []any{"a", "b"},
+				"empty":    nil,
+				"1":        "numeric key",
+				"created":  "2024-01-02T03:04:05Z",
+			},
+		},
+		{
+			name:   "YAML scalar document",
+			format: types.FormatYAML,
+			data:   "just a string\n",
+			want:   "just a string",
+		},
+		{
+			name:   "YAML empty document",
+			format: types.FormatYAML,
+			data:   "",
+			want:   nil,
+		},
+		{
+			name:    "YAML multiple documents",
+			format:  types.FormatYAML,
+			data:    "name: app\n---\nname: other\n",
+			wantErr: "multiple YAML documents",
+		},
+		{
+			name:    "YAML infinity",
+			format:  types.FormatYAML,
+			data:    "value: .inf\n",
+			wantErr: "can't be represented in JSON",
+		},
+		{
+			name:   "TOML",
+			format: types.FormatTOML,
+			data: `title = "app"
+date = 2024-01-02
+at = 07:32:00
+updated = 2024-01-02T03:04:05+01:00
+ports = [80, 443]
+
+[[servers]]
+host = "a"
+weight = 1.5
+`,
+			want: map[string]any{
+				"title":   "app",
+				"date":    "2024-01-02",
+				"at":      "07:32:00",
+				"updated": "2024-01-02T03:04:05+01:00",
+				"ports":   []any{json.Number("80"), json.Number("443")},
+				"servers": []any{
+					map[string]any{"host": "a", "weight": json.Number("1.5")},
+				},
+			},
+		},
+		{
+			name:    "TOML syntax error",
+			format:  types.FormatTOML,
+			data:    "title = ",
+			wantErr: "failed to parse toml from out",
+		},
+		{
+			name:    "Invalid format",
+			format:  "ini",
+			data:    "a=b",
+			wantErr: "invalid actualFormat",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseOutput([]byte(tt.data), tt.format, "out")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseOutput() error = %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseOutput() unexpected error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseOutput() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
@@ -2,20 +2,83 @@ package nix
 
 import (
 	"bytes"
-	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
+	"time"
 
+	"github.com/rs/zerolog/log"
 	apperrors "gitlab.com/TECHNOFAB/nixtest/internal/errors"
+	"gitlab.com/TECHNOFAB/nixtest/internal/subresults"
+	"gitlab.com/TECHNOFAB/nixtest/internal/types"
+	"gitlab.com/TECHNOFAB/nixtest/internal/util"
 )
 
 // Service defines operations related to Nix
 type Service interface {
 	BuildDerivation(derivation string) (string, error)
-	BuildAndParseJSON(derivation string) (any, error)
-	BuildAndRunScript(derivation string, impureEnv bool) (exitCode int, stdout string, stderr string, err error)
+	BuildLog(derivation string) (string, error)
+	BuildAndParse(derivation string, format types.Format) (any, error)
+	BuildAndRunScript(derivation string, opts ScriptOptions) (ScriptResult, error)
+	BuildAndRunVM(driver string, opts ScriptOptions) (ScriptResult, error)
+	Eval(target EvalTarget) (any, error)
+}
+
+// ScriptOptions configures how a script derivation is run
+type ScriptOptions struct {
+	// ImpureEnv keeps the current environment instead of running with `env -i`
+	ImpureEnv bool
+	// Fixtures is a store path or derivation whose contents are copied
+	// into the working directory before the script runs
+	Fixtures string
+	// Interpreter runs the built script, defaults to bash
+	Interpreter string
+	// Entrypoint runs <output>/bin/<Entrypoint> directly instead of passing the output to an interpreter
+	Entrypoint string
+	// Args are passed to the script
+	Args []string
+	// Stdin is fed to the script's standard input
+	Stdin string
+	// Limits are applied to the script process using rlimits
+	Limits types.ResourceLimits
+	// OutputLimit caps how many bytes of stdout and stderr are kept each, <= 0 means unlimited
+	OutputLimit int
+	// SpillDir is where the full output is written to if it exceeds OutputLimit, empty to disable
+	SpillDir string
+	// LiveStdout and LiveStderr receive the script's output while it runs, in
+	// addition to it being captured. Nix build logs are written to LiveStderr
+	LiveStdout io.Writer
+	LiveStderr io.Writer
+}
+
+// ScriptResult holds the outcome of a script run
+type ScriptResult struct {
+	ExitCode int
+	Stdout   string
+	Stderr   string
+	// WorkDir is the temporary directory the script ran in, empty if it was never created
+	WorkDir string
+	// ArtifactsDir is the directory exposed as $NIXTEST_ARTIFACTS, empty if it was never created
+	ArtifactsDir string
+	// Results is whatever the script wrote to $NIXTEST_RESULTS
+	Results string
+	// PeakRSS is the maximum resident set size in bytes
+	PeakRSS int64
+	// CPUTime is the user + system CPU time of the script
+	CPUTime time.Duration
+	// Signal describes the signal which killed the script, if any
+	Signal string
+	// LimitExceeded describes which resource limit the script exceeded, if any
+	LimitExceeded string
+	// StdoutFile and StderrFile contain the full output if it got truncated and spilled
+	StdoutFile string
+	StderrFile string
+	// SubResults are parsed from the whole output while it's written: TAP on
+	// stdout of scripts and the subtest markers of VM tests
+	SubResults []types.SubResult
 }
 
 type DefaultService struct {
@@ -28,16 +91,27 @@ func NewDefaultService() *DefaultService {
 
 // BuildDerivation builds a Nix derivation and returns the output path
 func (s *DefaultService) BuildDerivation(derivation string) (string, error) {
-	cmd := s.commandExecutor(
-		"nix",
+	return s.buildDerivation(derivation, nil)
+}
+
+// buildDerivation builds a Nix derivation, streaming the build logs to live if set
+func (s *DefaultService) buildDerivation(derivation string, live io.Writer) (string, error) {
+	args := []string{
 		"build",
-		derivation+"^*",
+		derivation + "^*",
 		"--print-out-paths",
 		"--no-link",
-	)
+	}
+	if live != nil {
+		args = append(args, "--print-build-logs")
+	}
+	cmd := s.commandExecutor("nix", args...)
 	var stdout, stderr bytes.Buffer
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
+	if live != nil {
+		cmd.Stderr = io.MultiWriter(&stderr, live)
+	}
 
 	err := cmd.Run()
 	if err != nil {
@@ -51,8 +125,9 @@ func (s *DefaultService) BuildDerivation(derivation string) (string, error) {
 	return path, nil
 }
 
-// BuildAndParseJSON builds a derivation and parses its output file as JSON
-func (s *DefaultService) BuildAndParseJSON(derivation string) (any, error) {
+// BuildAndParse builds a derivation and parses its output file in the given
+// format (JSON if empty), see parseOutput
+func (s *DefaultService) BuildAndParse(derivation string, format types.Format) (any, error) {
 	path, err := s.BuildDerivation(derivation)
 	if err != nil {
 		return nil, err
@@ -63,57 +138,322 @@ func (s *DefaultService) BuildAndParseJSON(derivation string) (any, error) {
 		return nil, &apperrors.FileReadError{Path: path, Err: err}
 	}
 
-	var result any
-	err = json.Unmarshal(data, &result)
+	return parseOutput(data, format, path)
+}
+
+// BuildAndRunScript builds a derivation and runs it as a script.
+// The script runs in a fresh temporary directory which is returned as WorkDir,
+// files it writes to $NIXTEST_ARTIFACTS end up in ArtifactsDir.
+// The caller is responsible for removing both.
+func (s *DefaultService) BuildAndRunScript(derivation string, opts ScriptOptions) (result ScriptResult, err error) {
+	result.ExitCode = -1
+	path, resultsFile, err := s.prepareScript(derivation, opts, &result)
+	if resultsFile != "" {
+		defer os.Remove(resultsFile)
+	}
 	if err != nil {
-		return nil, &apperrors.JSONUnmarshalError{Source: path, Err: err}
+		return result, err
 	}
 
+	// TAP is parsed while the script runs, as the captured stdout may be truncated
+	tap := subresults.NewTAPParser()
+	tapLines := util.NewLineWriter(tap.Line)
+	cmdArgs := limitsCommand(append(scriptCommand(path, opts), opts.Args...), opts.Limits)
+	result, err = s.runScript(s.scriptEnvCommand(cmdArgs, opts, result, resultsFile), path, opts, result, resultsFile, tapLines, nil)
+	tapLines.Flush()
+	if tapResults, isTAP := tap.Results(); isTAP {
+		result.SubResults = tapResults
+	}
+	return result, err
+}
+
+// BuildAndRunVM builds a NixOS test driver and runs it like a script. The
+// working directory doubles as TMPDIR so parallel VM tests don't share sockets.
+// Screenshots etc. and the serial console log of every machine end up in ArtifactsDir.
+// The caller is responsible for removing WorkDir and ArtifactsDir.
+func (s *DefaultService) BuildAndRunVM(driver string, opts ScriptOptions) (result ScriptResult, err error) {
+	result.ExitCode = -1
+	path, resultsFile, err := s.prepareScript(driver, opts, &result)
+	if resultsFile != "" {
+		defer os.Remove(resultsFile)
+	}
+	if err != nil {
+		return result, err
+	}
+
+	cmdArgs := limitsCommand([]string{
+		filepath.Join(path, "bin", "nixos-test-driver"),
+		"--output_directory", result.ArtifactsDir,
+	}, opts.Limits)
+	cmd := s.scriptEnvCommand(cmdArgs, opts, result, resultsFile, "TMPDIR="+result.WorkDir)
+
+	serial := newSerialLogs(result.ArtifactsDir)
+	defer serial.Close()
+	// subtests are parsed while the driver runs, as the captured stderr may be truncated
+	subtests := subresults.NewNixOSTestParser()
+	subtestLines := util.NewLineWriter(subtests.Line)
+	// the driver logs everything to stderr, but don't rely on it
+	result, err = s.runScript(cmd, path, opts, result, resultsFile, serial.Writer(), io.MultiWriter(serial.Writer(), subtestLines))
+	subtestLines.Flush()
+	result.SubResults = subtests.Results()
+	return result, err
+}
+
+// runScript runs the prepared cmd, capturing its output and resource usage.
+// extraStdout and extraStderr additionally receive the respective output if set
+func (s *DefaultService) runScript(cmd *exec.Cmd, path string, opts ScriptOptions, result ScriptResult, resultsFile string, extraStdout, extraStderr io.Writer) (ScriptResult, error) {
+	if opts.Stdin != "" {
+		cmd.Stdin = strings.NewReader(opts.Stdin)
+	}
+	outBuf, outSpill, err := newOutputBuffer(opts, "stdout.log")
+	if err != nil {
+		return result, &apperrors.ScriptExecutionError{Path: path, Err: err}
+	}
+	errBuf, errSpill, err := newOutputBuffer(opts, "stderr.log")
+	if err != nil {
+		closeSpill(outSpill, false)
+		return result, &apperrors.ScriptExecutionError{Path: path, Err: err}
+	}
+	cmd.Stdout = teeWriter(outBuf, opts.LiveStdout, extraStdout)
+	cmd.Stderr = teeWriter(errBuf, opts.LiveStderr, extraStderr)
+
+	if err = cmd.Start(); err != nil {
+		closeSpill(outSpill, false)
+		closeSpill(errSpill, false)
+		return result, &apperrors.ScriptExecutionError{Path: path, Err: err}
+	}
+
+	runErr := cmd.Wait()
+	result.Stdout = outBuf.String()
+	result.Stderr = errBuf.String()
+	result.StdoutFile = closeSpill(outSpill, outBuf.Truncated())
+	result.StderrFile = closeSpill(errSpill, errBuf.Truncated())
+	result.PeakRSS, result.CPUTime = processUsage(cmd.ProcessState)
+
+	if results, readErr := os.ReadFile(resultsFile); readErr == nil {
+		result.Results = string(results)
+	}
+
+	if runErr != nil {
+		if exitErr, ok := runErr.(*exec.ExitError); ok {
+			result.ExitCode = exitErr.ExitCode()
+			if signal, ok := exitSignal(cmd.ProcessState); ok {
+				result.Signal = signal.String()
+			}
+			result.LimitExceeded = limitExceeded(opts.Limits, cmd.ProcessState, result.ExitCode, result.PeakRSS, result.CPUTime, result.Stderr)
+			return result, nil
+		}
+		return result, &apperrors.ScriptExecutionError{Path: path, Err: runErr}
+	}
+
+	result.ExitCode = 0
 	return result, nil
 }
 
-// BuildAndRunScript builds a derivation and runs it as a script
-func (s *DefaultService) BuildAndRunScript(derivation string, impureEnv bool) (exitCode int, stdout string, stderr string, err error) {
-	exitCode = -1
-	path, err := s.BuildDerivation(derivation)
+// DebugScript prepares a script exactly like BuildAndRunScript, but starts an
+// interactive shell in its working directory instead of running it.
+// The caller is responsible for removing WorkDir and ArtifactsDir.
+func (s *DefaultService) DebugScript(derivation string, opts ScriptOptions) (result ScriptResult, err error) {
+	result.ExitCode = -1
+	path, resultsFile, err := s.prepareScript(derivation, opts, &result)
+	if resultsFile != "" {
+		defer os.Remove(resultsFile)
+	}
+	if err != nil {
+		return result, err
+	}
+
+	// the environment might be cleared, so resolve the shell beforehand
+	shell, err := exec.LookPath("bash")
+	if err != nil {
+		return result, &apperrors.ScriptExecutionError{Path: path, Err: err}
+	}
+	log.Info().
+		Str("dir", result.WorkDir).
+		Str("command", strings.Join(append(scriptCommand(path, opts), opts.Args...), " ")).
+		Msg("Starting debug shell, exit it to continue")
+
+	cmd := s.scriptEnvCommand([]string{shell, "-i"}, opts, result, resultsFile)
+	result.ExitCode, err = runInteractive(cmd)
+	if err != nil {
+		return result, &apperrors.ScriptExecutionError{Path: path, Err: err}
+	}
+	return result, nil
+}
+
+// DebugVM builds a NixOS test driver and starts it interactively in a fresh
+// temporary directory, which is also used as its TMPDIR and returned as WorkDir.
+// The caller is responsible for removing it.
+func (s *DefaultService) DebugVM(driver string) (result ScriptResult, err error) {
+	result.ExitCode = -1
+	path, err := s.BuildDerivation(driver)
 	if err != nil {
-		return exitCode, "", "", err
+		return result, err
+	}
+
+	tempDir, err := os.MkdirTemp("", "nixtest-vm-")
+	if err != nil {
+		return result, &apperrors.ScriptExecutionError{Path: path, Err: fmt.Errorf("failed to create temporary directory: %w", err)}
+	}
+	result.WorkDir = tempDir
+
+	cmd := s.commandExecutor(filepath.Join(path, "bin", "nixos-test-driver"), "--interactive")
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, "TMPDIR="+tempDir)
+	cmd.Dir = tempDir
+	result.ExitCode, err = runInteractive(cmd)
+	if err != nil {
+		return result, &apperrors.ScriptExecutionError{Path: path, Err: err}
+	}
+	return result, nil
+}
+
+// prepareScript builds the script and creates its working directory (containing
+// the fixtures), artifacts directory and results file. The directories are set
+// in result as soon as they exist, the results file has to be removed by the caller.
+func (s *DefaultService) prepareScript(derivation string, opts ScriptOptions, result *ScriptResult) (path string, resultsFile string, err error) {
+	path, err = s.buildDerivation(derivation, opts.LiveStderr)
+	if err != nil {
+		return "", "", err
 	}
 
 	// run scripts in a temporary directory
 	tempDir, err := os.MkdirTemp("", "nixtest-script-")
 	if err != nil {
-		return exitCode, "", "", &apperrors.ScriptExecutionError{Path: path, Err: fmt.Errorf("failed to create temporary directory: %w", err)}
+		return path, "", &apperrors.ScriptExecutionError{Path: path, Err: fmt.Errorf("failed to create temporary directory: %w", err)}
 	}
-	defer os.RemoveAll(tempDir)
+	result.WorkDir = tempDir
 
-	var cmdArgs []string
-	if impureEnv {
-		cmdArgs = []string{"bash", path}
-	} else {
-		cmdArgs = append([]string{"env", "-i"}, "bash", path)
+	if opts.Fixtures != "" {
+		if err = s.copyFixtures(opts.Fixtures, tempDir, opts.LiveStderr); err != nil {
+			return path, "", &apperrors.ScriptExecutionError{Path: path, Err: err}
+		}
+	}
+
+	artifactsDir, err := os.MkdirTemp("", "nixtest-artifacts-")
+	if err != nil {
+		return path, "", &apperrors.ScriptExecutionError{Path: path, Err: fmt.Errorf("failed to create artifacts directory: %w", err)}
+	}
+	result.ArtifactsDir = artifactsDir
+
+	file, err := os.CreateTemp("", "nixtest-results-")
+	if err != nil {
+		return path, "", &apperrors.ScriptExecutionError{Path: path, Err: fmt.Errorf("failed to create results file: %w", err)}
+	}
+	file.Close()
+	return path, file.Name(), nil
+}
+
+// scriptEnvCommand creates the command for cmdArgs, running in the script's
+// working directory with the nixtest variables and extraEnv set
+func (s *DefaultService) scriptEnvCommand(cmdArgs []string, opts ScriptOptions, result ScriptResult, resultsFile string, extraEnv ...string) *exec.Cmd {
+	env := append([]string{
+		"NIXTEST_ARTIFACTS=" + result.ArtifactsDir,
+		"NIXTEST_RESULTS=" + resultsFile,
+	}, extraEnv...)
+	if !opts.ImpureEnv {
+		// env -i clears the environment, so pass our variables as arguments
+		cmdArgs = append(append([]string{"env", "-i"}, env...), cmdArgs...)
 	}
 
 	cmd := s.commandExecutor(cmdArgs[0], cmdArgs[1:]...)
-	cmd.Dir = tempDir
-	var outBuf, errBuf bytes.Buffer
-	cmd.Stdout = &outBuf
-	cmd.Stderr = &errBuf
+	if opts.ImpureEnv {
+		if cmd.Env == nil {
+			cmd.Env = os.Environ()
+		}
+		cmd.Env = append(cmd.Env, env...)
+	}
+	cmd.Dir = result.WorkDir
+	return cmd
+}
 
-	if err = cmd.Start(); err != nil {
-		return exitCode, "", "", &apperrors.ScriptExecutionError{Path: path, Err: err}
+// runInteractive runs cmd attached to the terminal and returns its exit code,
+// a non-zero exit code is not an error
+func runInteractive(cmd *exec.Cmd) (int, error) {
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err := cmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return exitErr.ExitCode(), nil
+	}
+	if err != nil {
+		return -1, err
 	}
+	return 0, nil
+}
 
-	runErr := cmd.Wait()
-	stdout = outBuf.String()
-	stderr = errBuf.String()
+// newOutputBuffer creates a buffer capped to the output limit, which spills
+// the full output to a file in the spill dir (if configured)
+func newOutputBuffer(opts ScriptOptions, name string) (*util.CappedBuffer, *os.File, error) {
+	if opts.SpillDir == "" || opts.OutputLimit <= 0 {
+		return util.NewCappedBuffer(opts.OutputLimit, nil), nil, nil
+	}
+	if err := os.MkdirAll(opts.SpillDir, 0755); err != nil {
+		return nil, nil, fmt.Errorf("failed to create spill directory: %w", err)
+	}
+	file, err := os.Create(filepath.Join(opts.SpillDir, name))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create spill file: %w", err)
+	}
+	return util.NewCappedBuffer(opts.OutputLimit, file), file, nil
+}
 
-	if runErr != nil {
-		if exitErr, ok := runErr.(*exec.ExitError); ok {
-			return exitErr.ExitCode(), stdout, stderr, nil
+// teeWriter additionally passes everything written to buf on to all non-nil writers
+func teeWriter(buf io.Writer, writers ...io.Writer) io.Writer {
+	all := []io.Writer{buf}
+	for _, w := range writers {
+		if w != nil {
+			all = append(all, w)
 		}
-		return exitCode, stdout, stderr, &apperrors.ScriptExecutionError{Path: path, Err: runErr}
 	}
+	if len(all) == 1 {
+		return buf
+	}
+	return io.MultiWriter(all...)
+}
 
-	return 0, stdout, stderr, nil
+// closeSpill closes the spill file and returns its path if it should be kept,
+// otherwise it gets removed
+func closeSpill(file *os.File, keep bool) string {
+	if file == nil {
+		return ""
+	}
+	file.Close()
+	if keep {
+		return file.Name()
+	}
+	os.Remove(file.Name())
+	return ""
+}
+
+// scriptCommand returns the command which runs the built script at path
+func scriptCommand(path string, opts ScriptOptions) []string {
+	switch {
+	case opts.Entrypoint != "":
+		return []string{filepath.Join(path, "bin", opts.Entrypoint)}
+	case opts.Interpreter != "":
+		return []string{opts.Interpreter, path}
+	default:
+		return []string{"bash", path}
+	}
+}
+
+// copyFixtures copies the fixtures (building them first if they are a derivation) into dir
+func (s *DefaultService) copyFixtures(fixtures string, dir string, live io.Writer) error {
+	src := fixtures
+	if strings.HasSuffix(fixtures, ".drv") {
+		var err error
+		src, err = s.buildDerivation(fixtures, live)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := util.CopyDir(src, dir); err != nil {
+		return fmt.Errorf("failed to copy fixtures %s: %w", src, err)
+	}
+	return nil
 }
@@ -4,13 +4,17 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"reflect"
+	"slices"
 	"strings"
 	"testing"
 
 	apperrors "gitlab.com/TECHNOFAB/nixtest/internal/errors"
+	"gitlab.com/TECHNOFAB/nixtest/internal/types"
 )
 
 func TestHelperProcess(t *testing.T) {
@@ -33,6 +37,28 @@ func TestHelperProcess(t *testing.T) {
 
 	switch cmd {
 	case "nix":
+		if len(params) > 0 && params[0] == "eval" {
+			if mockError := os.Getenv("MOCK_NIX_EVAL_ERROR"); mockError != "" {
+				fmt.Fprintln(os.Stderr, mockError)
+				os.Exit(1)
+			}
+			if os.Getenv("MOCK_NIX_EVAL_EXIT_CODE") == "1" {
+				os.Exit(1)
+			}
+			if os.Getenv("MOCK_NIX_EVAL_ECHO_ARGS") == "1" {
+				_ = json.NewEncoder(os.Stdout).Encode(params)
+				return
+			}
+			fmt.Fprintln(os.Stdout, os.Getenv("MOCK_NIX_EVAL_OUTPUT"))
+		}
+		if len(params) > 0 && params[0] == "log" {
+			if mockLog := os.Getenv("MOCK_NIX_LOG"); mockLog != "" {
+				fmt.Fprint(os.Stdout, mockLog)
+				return
+			}
+			fmt.Fprintf(os.Stderr, "error: build log of '%s' is not available\n", params[1])
+			os.Exit(1)
+		}
 		if len(params) > 0 && params[0] == "build" {
 			mockOutput := os.Getenv("MOCK_NIX_BUILD_OUTPUT")
 			mockError := os.Getenv("MOCK_NIX_BUILD_ERROR")
@@ -41,6 +67,9 @@ func TestHelperProcess(t *testing.T) {
 			if mockError != "" {
 				fmt.Fprintln(os.Stderr, mockError)
 			}
+			if slices.Contains(params, "--print-build-logs") {
+				fmt.Fprintln(os.Stderr, os.Getenv("MOCK_NIX_BUILD_LOG"))
+			}
 			if mockExitCode != "" && mockExitCode != "0" {
 				os.Exit(1) // simplified exit for helper
 			}
@@ -48,23 +77,47 @@ func TestHelperProcess(t *testing.T) {
 				fmt.Fprintln(os.Stdout, mockOutput)
 			}
 		}
-	case "bash", "env":
-		scriptPath := params[0]
-		if cmd == "env" && len(params) > 2 {
-			scriptPath = params[2]
-		}
-		if _, err := os.Stat(scriptPath); err != nil && !os.IsNotExist(err) {
-			fmt.Fprintf(os.Stderr, "mocked script: script path %s could not be statted: %v\n", scriptPath, err)
-			os.Exit(3)
-		}
-		fmt.Fprint(os.Stdout, os.Getenv("MOCK_SCRIPT_STDOUT"))
-		fmt.Fprint(os.Stderr, os.Getenv("MOCK_SCRIPT_STDERR"))
-		if code := os.Getenv("MOCK_SCRIPT_EXIT_CODE"); code != "" && code != "0" {
-			os.Exit(5) // custom exit for script failure
+	case "env":
+		// skip "-i" and variable assignments
+		for len(params) > 0 && (params[0] == "-i" || strings.Contains(params[0], "=")) {
+			if key, value, ok := strings.Cut(params[0], "="); ok {
+				os.Setenv(key, value)
+			}
+			params = params[1:]
 		}
+		mockScript(params[0], params[1:])
 	default:
-		fmt.Fprintf(os.Stderr, "mocked command: unknown command %s\n", cmd)
-		os.Exit(126)
+		mockScript(cmd, params)
+	}
+}
+
+// mockScript simulates running a script with an interpreter or directly
+func mockScript(program string, params []string) {
+	scriptPath := program
+	if len(params) > 0 && !strings.HasPrefix(program, "/") {
+		scriptPath = params[0]
+	}
+	if _, err := os.Stat(scriptPath); err != nil && !os.IsNotExist(err) {
+		fmt.Fprintf(os.Stderr, "mocked script: script path %s could not be statted: %v\n", scriptPath, err)
+		os.Exit(3)
+	}
+	if os.Getenv("MOCK_SCRIPT_ECHO_ARGS") == "1" {
+		stdin, _ := io.ReadAll(os.Stdin)
+		fmt.Fprintf(os.Stdout, "program=%s args=%s stdin=%s\n", program, strings.Join(params, ","), stdin)
+	}
+	if os.Getenv("MOCK_SCRIPT_LIST_CWD") == "1" {
+		entries, _ := os.ReadDir(".")
+		for _, entry := range entries {
+			fmt.Fprintln(os.Stdout, entry.Name())
+		}
+	}
+	if artifact := os.Getenv("MOCK_SCRIPT_ARTIFACT"); artifact != "" {
+		_ = os.WriteFile(filepath.Join(os.Getenv("NIXTEST_ARTIFACTS"), artifact), []byte("artifact"), 0644)
+	}
+	fmt.Fprint(os.Stdout, os.Getenv("MOCK_SCRIPT_STDOUT"))
+	fmt.Fprint(os.Stderr, os.Getenv("MOCK_SCRIPT_STDERR"))
+	if code := os.Getenv("MOCK_SCRIPT_EXIT_CODE"); code != "" && code != "0" {
+		os.Exit(5) // custom exit for script failure
 	}
 }
 
@@ -137,7 +190,7 @@ func TestDefaultService_BuildDerivation(t *testing.T) {
 	}
 }
 
-func TestDefaultService_BuildAndParseJSON(t *testing.T) {
+func TestDefaultService_BuildAndParse(t *testing.T) {
 	service := NewDefaultService()
 	mockExecCommandForService(service)
 
@@ -147,6 +200,7 @@ func TestDefaultService_BuildAndParseJSON(t *testing.T) {
 	tests := []struct {
 		name               string
 		derivation         string
+		format             types.Format
 		mockBuildOutput    string
 		mockJSONContent    string
 		mockBuildError     string
@@ -157,21 +211,41 @@ func TestDefaultService_BuildAndParseJSON(t *testing.T) {
 		wantErrMsgContains string
 	}{
 		{
-			"Success", "some.drv#json", mockDrvOutputPath, `{"key": "value"}`, "", "0",
+			"Success", "some.drv#json", "", mockDrvOutputPath, `{"key": "value"}`, "", "0",
 			map[string]any{"key": "value"}, false, nil, "",
 		},
 		{
-			"BuildDerivation fails", "error.drv#json", "", "", "nix build error", "1",
+			"BuildDerivation fails", "error.drv#json", "", "", "", "nix build error", "1",
 			nil, true, (*apperrors.NixBuildError)(nil), "nix build error",
 		},
 		{
-			"ReadFile fails", "readfail.drv#json", "/nonexistent/path/output.json", "", "", "0",
+			"ReadFile fails", "readfail.drv#json", "", "/nonexistent/path/output.json", "", "", "0",
 			nil, true, (*apperrors.FileReadError)(nil), "failed to read file",
 		},
 		{
-			"Unmarshal fails", "badjson.drv#json", mockDrvOutputPath, `{"key": "value"`, "", "0",
+			"Unmarshal fails", "badjson.drv#json", "", mockDrvOutputPath, `{"key": "value"`, "", "0",
 			nil, true, (*apperrors.JSONUnmarshalError)(nil), "failed to unmarshal JSON",
 		},
+		{
+			"YAML", "some.drv#yaml", types.FormatYAML, mockDrvOutputPath, "key: value\nport: 80\n", "", "0",
+			map[string]any{"key": "value", "port": json.Number("80")}, false, nil, "",
+		},
+		{
+			"TOML", "some.drv#toml", types.FormatTOML, mockDrvOutputPath, "key = \"value\"\n[server]\nport = 80\n", "", "0",
+			map[string]any{"key": "value", "server": map[string]any{"port": json.Number("80")}}, false, nil, "",
+		},
+		{
+			"Text", "some.drv#text", types.FormatText, mockDrvOutputPath, "{not json\n", "", "0",
+			"{not json\n", false, nil, "",
+		},
+		{
+			"YAML parse fails", "badyaml.drv#yaml", types.FormatYAML, mockDrvOutputPath, "key: [value\n", "", "0",
+			nil, true, (*apperrors.ParseError)(nil), "failed to parse yaml",
+		},
+		{
+			"Invalid format", "some.drv#ini", "ini", mockDrvOutputPath, "key=value\n", "", "0",
+			nil, true, nil, "invalid actualFormat \"ini\"",
+		},
 	}
 
 	for _, tt := range tests {
@@ -187,26 +261,145 @@ func TestDefaultService_BuildAndParseJSON(t *testing.T) {
 				defer os.Remove(mockDrvOutputPath)
 			}
 
-			got, err := service.BuildAndParseJSON(tt.derivation)
+			got, err := service.BuildAndParse(tt.derivation, tt.format)
 
 			if (err != nil) != tt.wantErr {
-				t.Fatalf("BuildAndParseJSON() error = %v, wantErr %v", err, tt.wantErr)
+				t.Fatalf("BuildAndParse() error = %v, wantErr %v", err, tt.wantErr)
 			}
 			if tt.wantErr {
 				if tt.wantErrType != nil && !errors.As(err, &tt.wantErrType) {
-					t.Errorf("BuildAndParseJSON() error type = %T, want %T", err, tt.wantErrType)
+					t.Errorf("BuildAndParse() error type = %T, want %T", err, tt.wantErrType)
 				}
 				if tt.wantErrMsgContains != "" && !strings.Contains(err.Error(), tt.wantErrMsgContains) {
-					t.Errorf("BuildAndParseJSON() error = %q, want error containing %q", err.Error(), tt.wantErrMsgContains)
+					t.Errorf("BuildAndParse() error = %q, want error containing %q", err.Error(), tt.wantErrMsgContains)
 				}
 			}
 			if !tt.wantErr && !jsonDeepEqual(got, tt.want) {
-				t.Errorf("BuildAndParseJSON() got = %v, want %v", got, tt.want)
+				t.Errorf("BuildAndParse() got = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
 
+func TestDefaultService_Eval(t *testing.T) {
+	service := NewDefaultService()
+	mockExecCommandForService(service)
+
+	tests := []struct {
+		name               string
+		target             EvalTarget
+		mockOutput         string
+		mockError          string
+		mockExitCode       string
+		echoArgs           bool
+		want               any
+		wantErrType        any
+		wantNoEvalErr      bool
+		wantErrMsgContains string
+	}{
+		{
+			name:       "Success",
+			target:     EvalTarget{Flake: ".", Attr: "lib.value"},
+			mockOutput: `{"big": 9007199254740993}`,
+			want:       map[string]any{"big": json.Number("9007199254740993")},
+		},
+		{
+			name:     "Flake arguments",
+			target:   EvalTarget{Flake: "path:/src", Attr: "checks.x"},
+			echoArgs: true,
+			want:     []any{"eval", "--json", "--show-trace", "path:/src#checks.x"},
+		},
+		{
+			name:     "File arguments",
+			target:   EvalTarget{File: "/src/tests.nix", Attr: "a.b"},
+			echoArgs: true,
+			want:     []any{"eval", "--json", "--show-trace", "--file", "/src/tests.nix", "a.b"},
+		},
+		{
+			name:               "Evaluation error",
+			target:             EvalTarget{File: "/src/tests.nix"},
+			mockError:          "error: value is null",
+			wantErrType:        (*apperrors.NixEvalError)(nil),
+			wantErrMsgContains: "error: value is null",
+		},
+		{
+			name:               "Non-zero exit without error message",
+			target:             EvalTarget{File: "/src/tests.nix"},
+			mockExitCode:       "1",
+			wantNoEvalErr:      true,
+			wantErrMsgContains: "failed to run nix eval for /src/tests.nix: exit status 1",
+		},
+		{
+			name:               "Invalid JSON",
+			target:             EvalTarget{File: "/src/tests.nix"},
+			mockOutput:         `{"a":`,
+			wantErrType:        (*apperrors.JSONUnmarshalError)(nil),
+			wantErrMsgContains: "failed to unmarshal JSON",
+		},
+		{
+			name:               "Missing target",
+			target:             EvalTarget{Attr: "a"},
+			wantErrMsgContains: "is required",
+		},
+		{
+			name:               "Flake and file",
+			target:             EvalTarget{Flake: ".", File: "/src/tests.nix", Attr: "a"},
+			wantErrMsgContains: "only one of flake or file",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("MOCK_NIX_EVAL_OUTPUT", tt.mockOutput)
+			t.Setenv("MOCK_NIX_EVAL_ERROR", tt.mockError)
+			t.Setenv("MOCK_NIX_EVAL_EXIT_CODE", tt.mockExitCode)
+			if tt.echoArgs {
+				t.Setenv("MOCK_NIX_EVAL_ECHO_ARGS", "1")
+			}
+
+			got, err := service.Eval(tt.target)
+			if tt.wantErrMsgContains != "" {
+				if err == nil {
+					t.Fatalf("Eval() expected error containing %q", tt.wantErrMsgContains)
+				}
+				if tt.wantErrType != nil && !errors.As(err, &tt.wantErrType) {
+					t.Errorf("Eval() error type = %T, want %T", err, tt.wantErrType)
+				}
+				var evalErr *apperrors.NixEvalError
+				if tt.wantNoEvalErr && errors.As(err, &evalErr) {
+					t.Errorf("Eval() error = %v is a NixEvalError, want a plain error", err)
+				}
+				if !strings.Contains(err.Error(), tt.wantErrMsgContains) {
+					t.Errorf("Eval() error = %q, want error containing %q", err.Error(), tt.wantErrMsgContains)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Eval() unexpected error = %v", err)
+			}
+			if !jsonDeepEqual(got, tt.want) {
+				t.Errorf("Eval() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDefaultService_Eval_NixNotRunnable(t *testing.T) {
+	service := NewDefaultService()
+	service.commandExecutor = func(command string, args ...string) *exec.Cmd {
+		return exec.Command(filepath.Join(t.TempDir(), "nix"), args...)
+	}
+
+	_, err := service.Eval(EvalTarget{File: "/src/tests.nix", Attr: "bad"})
+	var evalErr *apperrors.NixEvalError
+	if err == nil || errors.As(err, &evalErr) {
+		t.Fatalf("Eval() error = %v, want a plain error", err)
+	}
+	if !strings.Contains(err.Error(), "failed to run nix eval for /src/tests.nix bad") {
+		t.Errorf("Eval() error = %q, want it to say nix eval couldn't run", err)
+	}
+}
+
 func jsonDeepEqual(a, b any) bool {
 	if a == nil && b == nil {
 		return true
@@ -277,7 +470,12 @@ func TestDefaultService_BuildAndRunScript(t *testing.T) {
 			os.Setenv("MOCK_SCRIPT_STDERR", tt.mockScriptStderr)
 			os.Setenv("MOCK_SCRIPT_EXIT_CODE", tt.mockScriptExitCode)
 
-			exitCode, stdout, stderr, err := service.BuildAndRunScript(tt.derivation, tt.impureEnv)
+			os.Setenv("MOCK_SCRIPT_ARTIFACT", "screenshot.png")
+			defer os.Unsetenv("MOCK_SCRIPT_ARTIFACT")
+
+			result, err := service.BuildAndRunScript(tt.derivation, ScriptOptions{ImpureEnv: tt.impureEnv})
+			defer os.RemoveAll(result.WorkDir)
+			defer os.RemoveAll(result.ArtifactsDir)
 
 			if (err != nil) != tt.wantErr {
 				t.Fatalf("BuildAndRunScript() error = %v, wantErr %v", err, tt.wantErr)
@@ -290,16 +488,336 @@ func TestDefaultService_BuildAndRunScript(t *testing.T) {
 					t.Errorf("BuildAndRunScript() error = %q, want error containing %q", err.Error(), tt.wantErrMsgContains)
 				}
 			} else {
-				if exitCode != tt.wantExitCode {
-					t.Errorf("BuildAndRunScript() exitCode = %v, want %v", exitCode, tt.wantExitCode)
+				if result.ExitCode != tt.wantExitCode {
+					t.Errorf("BuildAndRunScript() exitCode = %v, want %v", result.ExitCode, tt.wantExitCode)
 				}
-				if stdout != tt.wantStdout {
-					t.Errorf("BuildAndRunScript() stdout = %q, want %q", stdout, tt.wantStdout)
+				if result.Stdout != tt.wantStdout {
+					t.Errorf("BuildAndRunScript() stdout = %q, want %q", result.Stdout, tt.wantStdout)
 				}
-				if stderr != tt.wantStderr {
-					t.Errorf("BuildAndRunScript() stderr = %q, want %q", stderr, tt.wantStderr)
+				if result.Stderr != tt.wantStderr {
+					t.Errorf("BuildAndRunScript() stderr = %q, want %q", result.Stderr, tt.wantStderr)
+				}
+				if _, statErr := os.Stat(result.WorkDir); statErr != nil {
+					t.Errorf("BuildAndRunScript() WorkDir %q should still exist: %v", result.WorkDir, statErr)
+				}
+				if _, statErr := os.Stat(filepath.Join(result.ArtifactsDir, "screenshot.png")); statErr != nil {
+					t.Errorf("BuildAndRunScript() artifact should have been written to $NIXTEST_ARTIFACTS: %v", statErr)
 				}
 			}
 		})
 	}
 }
+
+func TestDefaultService_BuildAndRunScript_Fixtures(t *testing.T) {
+	service := NewDefaultService()
+	mockExecCommandForService(service)
+
+	tempDir := t.TempDir()
+	mockScriptPath := filepath.Join(tempDir, "mock_script.sh")
+	if err := os.WriteFile(mockScriptPath, []byte("#!/bin/bash\nls"), 0755); err != nil {
+		t.Fatalf("Failed to create dummy mock script: %v", err)
+	}
+	fixturesDir := filepath.Join(tempDir, "fixtures")
+	if err := os.MkdirAll(filepath.Join(fixturesDir, "nested"), 0755); err != nil {
+		t.Fatalf("Failed to create fixtures dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(fixturesDir, "input.txt"), []byte("data"), 0444); err != nil {
+		t.Fatalf("Failed to create fixture file: %v", err)
+	}
+	// read-only like the store, restored so TempDir can be removed
+	for _, dir := range []string{filepath.Join(fixturesDir, "nested"), fixturesDir} {
+		if err := os.Chmod(dir, 0555); err != nil {
+			t.Fatalf("Failed to make fixtures read-only: %v", err)
+		}
+		t.Cleanup(func() { os.Chmod(dir, 0755) })
+	}
+
+	os.Setenv("MOCK_NIX_BUILD_OUTPUT", mockScriptPath)
+	os.Setenv("MOCK_NIX_BUILD_ERROR", "")
+	os.Setenv("MOCK_NIX_BUILD_EXIT_CODE", "0")
+	os.Setenv("MOCK_SCRIPT_STDOUT", "")
+	os.Setenv("MOCK_SCRIPT_STDERR", "")
+	os.Setenv("MOCK_SCRIPT_EXIT_CODE", "0")
+	os.Setenv("MOCK_SCRIPT_LIST_CWD", "1")
+	defer os.Unsetenv("MOCK_SCRIPT_LIST_CWD")
+
+	result, err := service.BuildAndRunScript("script.drv#sh", ScriptOptions{Fixtures: fixturesDir})
+	defer os.RemoveAll(result.WorkDir)
+	defer os.RemoveAll(result.ArtifactsDir)
+	if err != nil {
+		t.Fatalf("BuildAndRunScript() unexpected error = %v", err)
+	}
+	if result.ExitCode != 0 {
+		t.Errorf("BuildAndRunScript() exitCode = %v, want 0", result.ExitCode)
+	}
+	if result.Stdout != "input.txt\nnested\n" {
+		t.Errorf("BuildAndRunScript() stdout = %q, want fixtures to be listed", result.Stdout)
+	}
+
+	result, err = service.BuildAndRunScript("script.drv#sh", ScriptOptions{Fixtures: filepath.Join(tempDir, "missing")})
+	defer os.RemoveAll(result.WorkDir)
+	if err == nil {
+		t.Fatalf("BuildAndRunScript() expected error for missing fixtures")
+	}
+	var scriptErr *apperrors.ScriptExecutionError
+	if !errors.As(err, &scriptErr) {
+		t.Errorf("BuildAndRunScript() error type = %T, want *ScriptExecutionError", err)
+	}
+}
+
+func TestDefaultService_BuildAndRunScript_Command(t *testing.T) {
+	service := NewDefaultService()
+	mockExecCommandForService(service)
+
+	tempDir := t.TempDir()
+	os.Setenv("MOCK_NIX_BUILD_OUTPUT", tempDir)
+	os.Setenv("MOCK_NIX_BUILD_ERROR", "")
+	os.Setenv("MOCK_NIX_BUILD_EXIT_CODE", "0")
+	os.Setenv("MOCK_SCRIPT_STDOUT", "")
+	os.Setenv("MOCK_SCRIPT_STDERR", "")
+	os.Setenv("MOCK_SCRIPT_EXIT_CODE", "0")
+	os.Setenv("MOCK_SCRIPT_ECHO_ARGS", "1")
+	defer os.Unsetenv("MOCK_SCRIPT_ECHO_ARGS")
+
+	tests := []struct {
+		name       string
+		opts       ScriptOptions
+		wantStdout string
+	}{
+		{
+			"Default bash", ScriptOptions{},
+			fmt.Sprintf("program=bash args=%s stdin=\n", tempDir),
+		},
+		{
+			"Interpreter with args and stdin", ScriptOptions{Interpreter: "python3", Args: []string{"-v", "x"}, Stdin: "input"},
+			fmt.Sprintf("program=python3 args=%s,-v,x stdin=input\n", tempDir),
+		},
+		{
+			"Entrypoint impure", ScriptOptions{ImpureEnv: true, Entrypoint: "my-test", Args: []string{"a"}},
+			fmt.Sprintf("program=%s args=a stdin=\n", filepath.Join(tempDir, "bin", "my-test")),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := service.BuildAndRunScript("script.drv", tt.opts)
+			defer os.RemoveAll(result.WorkDir)
+			defer os.RemoveAll(result.ArtifactsDir)
+			if err != nil {
+				t.Fatalf("BuildAndRunScript() unexpected error = %v", err)
+			}
+			if result.Stdout != tt.wantStdout {
+				t.Errorf("BuildAndRunScript() stdout = %q, want %q", result.Stdout, tt.wantStdout)
+			}
+		})
+	}
+}
+
+func TestDefaultService_BuildAndRunScript_OutputLimit(t *testing.T) {
+	service := NewDefaultService()
+	mockExecCommandForService(service)
+
+	tempDir := t.TempDir()
+	spillDir := filepath.Join(tempDir, "spill")
+	os.Setenv("MOCK_NIX_BUILD_OUTPUT", tempDir)
+	os.Setenv("MOCK_NIX_BUILD_ERROR", "")
+	os.Setenv("MOCK_NIX_BUILD_EXIT_CODE", "0")
+	os.Setenv("MOCK_SCRIPT_STDOUT", "0123456789abcdefghij")
+	os.Setenv("MOCK_SCRIPT_STDERR", "short")
+	os.Setenv("MOCK_SCRIPT_EXIT_CODE", "0")
+
+	result, err := service.BuildAndRunScript("script.drv", ScriptOptions{OutputLimit: 10, SpillDir: spillDir})
+	defer os.RemoveAll(result.WorkDir)
+	defer os.RemoveAll(result.ArtifactsDir)
+	if err != nil {
+		t.Fatalf("BuildAndRunScript() unexpected error = %v", err)
+	}
+
+	if want := "01234\n[... 10 bytes elided ...]\nfghij"; result.Stdout != want {
+		t.Errorf("BuildAndRunScript() stdout = %q, want %q", result.Stdout, want)
+	}
+	if result.Stderr != "short" {
+		t.Errorf("BuildAndRunScript() stderr = %q, want %q", result.Stderr, "short")
+	}
+	if result.StdoutFile != filepath.Join(spillDir, "stdout.log") {
+		t.Errorf("BuildAndRunScript() StdoutFile = %q", result.StdoutFile)
+	}
+	if content, err := os.ReadFile(result.StdoutFile); err != nil || string(content) != "0123456789abcdefghij" {
+		t.Errorf("spilled stdout = %q, err = %v", content, err)
+	}
+	if result.StderrFile != "" {
+		t.Errorf("BuildAndRunScript() StderrFile = %q, want empty since it was not truncated", result.StderrFile)
+	}
+	if _, err := os.Stat(filepath.Join(spillDir, "stderr.log")); !os.IsNotExist(err) {
+		t.Errorf("untruncated stderr spill file should be removed, stat err = %v", err)
+	}
+}
+
+func TestDefaultService_BuildAndRunScript_TAPTruncated(t *testing.T) {
+	service := NewDefaultService()
+	mockExecCommandForService(service)
+
+	tempDir := t.TempDir()
+	filler := strings.Repeat("# some diagnostic output\n", 20)
+	os.Setenv("MOCK_NIX_BUILD_OUTPUT", tempDir)
+	os.Setenv("MOCK_NIX_BUILD_ERROR", "")
+	os.Setenv("MOCK_NIX_BUILD_EXIT_CODE", "0")
+	os.Setenv("MOCK_SCRIPT_STDOUT", "1..3\nok 1 - a\n"+filler+"not ok 2 - b\n"+filler+"ok 3 - c")
+	os.Setenv("MOCK_SCRIPT_STDERR", "")
+	os.Setenv("MOCK_SCRIPT_EXIT_CODE", "0")
+
+	result, err := service.BuildAndRunScript("script.drv", ScriptOptions{OutputLimit: 64})
+	defer os.RemoveAll(result.WorkDir)
+	defer os.RemoveAll(result.ArtifactsDir)
+	if err != nil {
+		t.Fatalf("BuildAndRunScript() unexpected error = %v", err)
+	}
+
+	// the middle of the captured stdout is elided, but all of it is parsed
+	want := []types.SubResult{
+		{Name: "a", Status: types.StatusSuccess},
+		{Name: "b", Status: types.StatusFailure, Message: "some diagnostic output\n" + strings.TrimSuffix(strings.Repeat("some diagnostic output\n", 19), "\n")},
+		{Name: "c", Status: types.StatusSuccess},
+	}
+	if !reflect.DeepEqual(result.SubResults, want) {
+		t.Errorf("BuildAndRunScript() SubResults = %#v, want %#v", result.SubResults, want)
+	}
+}
+
+func TestDefaultService_BuildAndRunScript_Live(t *testing.T) {
+	service := NewDefaultService()
+	mockExecCommandForService(service)
+
+	tempDir := t.TempDir()
+	os.Setenv("MOCK_NIX_BUILD_OUTPUT", tempDir)
+	os.Setenv("MOCK_NIX_BUILD_ERROR", "")
+	os.Setenv("MOCK_NIX_BUILD_EXIT_CODE", "0")
+	os.Setenv("MOCK_NIX_BUILD_LOG", "building script")
+	os.Setenv("MOCK_SCRIPT_STDOUT", "out\n")
+	os.Setenv("MOCK_SCRIPT_STDERR", "err\n")
+	os.Setenv("MOCK_SCRIPT_EXIT_CODE", "0")
+	defer os.Unsetenv("MOCK_NIX_BUILD_LOG")
+
+	var liveStdout, liveStderr strings.Builder
+	result, err := service.BuildAndRunScript("script.drv", ScriptOptions{LiveStdout: &liveStdout, LiveStderr: &liveStderr})
+	defer os.RemoveAll(result.WorkDir)
+	defer os.RemoveAll(result.ArtifactsDir)
+	if err != nil {
+		t.Fatalf("BuildAndRunScript() unexpected error = %v", err)
+	}
+
+	if liveStdout.String() != "out\n" {
+		t.Errorf("live stdout = %q, want %q", liveStdout.String(), "out\n")
+	}
+	if want := "building script\nerr\n"; liveStderr.String() != want {
+		t.Errorf("live stderr = %q, want %q", liveStderr.String(), want)
+	}
+	if result.Stdout != "out\n" || result.Stderr != "err\n" {
+		t.Errorf("BuildAndRunScript() should still capture output, got stdout %q, stderr %q", result.Stdout, result.Stderr)
+	}
+}
+
+func TestDefaultService_DebugScript(t *testing.T) {
+	service := NewDefaultService()
+	mockExecCommandForService(service)
+
+	tempDir := t.TempDir()
+	fixturesDir := filepath.Join(tempDir, "fixtures")
+	if err := os.MkdirAll(fixturesDir, 0755); err != nil {
+		t.Fatalf("Failed to create fixtures dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(fixturesDir, "input.txt"), []byte("data"), 0644); err != nil {
+		t.Fatalf("Failed to create fixture file: %v", err)
+	}
+
+	os.Setenv("MOCK_NIX_BUILD_OUTPUT", tempDir)
+	os.Setenv("MOCK_NIX_BUILD_ERROR", "")
+	os.Setenv("MOCK_NIX_BUILD_EXIT_CODE", "0")
+	os.Setenv("MOCK_SCRIPT_STDOUT", "")
+	os.Setenv("MOCK_SCRIPT_STDERR", "")
+	os.Setenv("MOCK_SCRIPT_EXIT_CODE", "1")
+	defer os.Setenv("MOCK_SCRIPT_EXIT_CODE", "0")
+
+	result, err := service.DebugScript("script.drv", ScriptOptions{Fixtures: fixturesDir})
+	defer os.RemoveAll(result.WorkDir)
+	defer os.RemoveAll(result.ArtifactsDir)
+	if err != nil {
+		t.Fatalf("DebugScript() unexpected error = %v", err)
+	}
+	// the exit code of the shell is passed through, not treated as an error
+	if result.ExitCode != 5 {
+		t.Errorf("DebugScript() exitCode = %d, want 5", result.ExitCode)
+	}
+	if _, err := os.Stat(filepath.Join(result.WorkDir, "input.txt")); err != nil {
+		t.Errorf("DebugScript() working directory should contain the fixtures, stat err = %v", err)
+	}
+	if _, err := os.Stat(result.ArtifactsDir); err != nil {
+		t.Errorf("DebugScript() artifacts directory should exist, stat err = %v", err)
+	}
+}
+
+func TestDefaultService_BuildAndRunVM(t *testing.T) {
+	service := NewDefaultService()
+	mockExecCommandForService(service)
+
+	tempDir := t.TempDir()
+	os.Setenv("MOCK_NIX_BUILD_OUTPUT", tempDir)
+	os.Setenv("MOCK_NIX_BUILD_ERROR", "")
+	os.Setenv("MOCK_NIX_BUILD_EXIT_CODE", "0")
+	os.Setenv("MOCK_SCRIPT_STDOUT", "")
+	os.Setenv("MOCK_SCRIPT_STDERR", "start all VMs\nmachine # [    0.000] Linux version\n\x1b[2mserver # booted\x1b[0m\nmachine # login:\n")
+	os.Setenv("MOCK_SCRIPT_EXIT_CODE", "0")
+	os.Setenv("MOCK_SCRIPT_ECHO_ARGS", "1")
+	defer os.Unsetenv("MOCK_SCRIPT_ECHO_ARGS")
+
+	result, err := service.BuildAndRunVM("driver.drv", ScriptOptions{})
+	defer os.RemoveAll(result.WorkDir)
+	defer os.RemoveAll(result.ArtifactsDir)
+	if err != nil {
+		t.Fatalf("BuildAndRunVM() unexpected error = %v", err)
+	}
+
+	wantStdout := fmt.Sprintf("program=%s args=--output_directory,%s stdin=\n", filepath.Join(tempDir, "bin", "nixos-test-driver"), result.ArtifactsDir)
+	if result.Stdout != wantStdout {
+		t.Errorf("BuildAndRunVM() stdout = %q, want %q", result.Stdout, wantStdout)
+	}
+	for file, want := range map[string]string{
+		"serial-machine.log": "[    0.000] Linux version\nlogin:\n",
+		"serial-server.log":  "booted\n",
+	} {
+		content, err := os.ReadFile(filepath.Join(result.ArtifactsDir, file))
+		if err != nil || string(content) != want {
+			t.Errorf("%s = %q (err %v), want %q", file, content, err, want)
+		}
+	}
+}
+
+func TestDefaultService_BuildAndRunVM_SubtestsTruncated(t *testing.T) {
+	service := NewDefaultService()
+	mockExecCommandForService(service)
+
+	tempDir := t.TempDir()
+	filler := strings.Repeat("machine # [    1.000] some kernel output\n", 50)
+	os.Setenv("MOCK_NIX_BUILD_OUTPUT", tempDir)
+	os.Setenv("MOCK_NIX_BUILD_ERROR", "")
+	os.Setenv("MOCK_NIX_BUILD_EXIT_CODE", "0")
+	os.Setenv("MOCK_SCRIPT_STDOUT", "")
+	os.Setenv("MOCK_SCRIPT_STDERR", "subtest: boots\n"+filler+"(finished: subtest: boots, in 1.00 seconds)\n"+filler+
+		"subtest: serves\n"+filler+"Test \"serves\" failed with error: \"timeout\"\n"+filler)
+	os.Setenv("MOCK_SCRIPT_EXIT_CODE", "0")
+
+	result, err := service.BuildAndRunVM("driver.drv", ScriptOptions{OutputLimit: 256})
+	defer os.RemoveAll(result.WorkDir)
+	defer os.RemoveAll(result.ArtifactsDir)
+	if err != nil {
+		t.Fatalf("BuildAndRunVM() unexpected error = %v", err)
+	}
+
+	// the markers are in the elided middle of the captured stderr, but all of it is parsed
+	want := []types.SubResult{
+		{Name: "boots", Status: types.StatusSuccess},
+		{Name: "serves", Status: types.StatusFailure, Message: "timeout"},
+	}
+	if !reflect.DeepEqual(result.SubResults, want) {
+		t.Errorf("BuildAndRunVM() SubResults = %#v, want %#v", result.SubResults, want)
+	}
+}
@@ -0,0 +1,61 @@
+package nix
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+
+	"gitlab.com/TECHNOFAB/nixtest/internal/util"
+)
+
+// the NixOS test driver prefixes serial console output with "<machine> # "
+var serialLineRegex = regexp.MustCompile(`^([\w-]+) # (.*)$`)
+
+// serialLogs splits the serial console output of the machines in a NixOS test
+// into one serial-<machine>.log file per machine in dir
+type serialLogs struct {
+	mu    sync.Mutex
+	dir   string
+	files map[string]*os.File
+}
+
+func newSerialLogs(dir string) *serialLogs {
+	return &serialLogs{dir: dir, files: map[string]*os.File{}}
+}
+
+// Writer returns a writer for a single output stream of the driver
+func (l *serialLogs) Writer() io.Writer {
+	return util.NewLineWriter(l.writeLine)
+}
+
+func (l *serialLogs) writeLine(line string) {
+	match := serialLineRegex.FindStringSubmatch(util.StripANSI(line))
+	if match == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	file, ok := l.files[match[1]]
+	if !ok {
+		var err error
+		file, err = os.Create(filepath.Join(l.dir, "serial-"+match[1]+".log"))
+		if err != nil {
+			// logs are best effort, don't fail the test because of them
+			return
+		}
+		l.files[match[1]] = file
+	}
+	_, _ = file.WriteString(match[2] + "\n")
+}
+
+// Close closes all log files
+func (l *serialLogs) Close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, file := range l.files {
+		file.Close()
+	}
+}
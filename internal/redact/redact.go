@@ -0,0 +1,140 @@
+package redact
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Placeholder replaces redacted values
+const Placeholder = "[redacted]"
+
+var segmentRegex = regexp.MustCompile(`^(?:\.([^.\[]+)|\[(\d+|\*)\]|\["((?:[^"\\]|\\.)*)"\])`)
+
+// Ignore returns a copy of value with everything at the paths removed, so it
+// is neither compared nor written to snapshots
+func Ignore(value any, paths []string) (any, error) {
+	return apply(value, paths, func(any) (any, bool) { return nil, false })
+}
+
+// Redact returns a copy of value with everything at the paths replaced by
+// Placeholder. Unlike ignored values, redacted ones still have to exist.
+func Redact(value any, paths []string) (any, error) {
+	return apply(value, paths, func(any) (any, bool) { return Placeholder, true })
+}
+
+// apply calls replace for every value matching one of the paths, the value
+// is removed if replace returns false
+func apply(value any, paths []string, replace func(any) (any, bool)) (any, error) {
+	for _, p := range paths {
+		segments, err := ParsePath(p)
+		if err != nil {
+			return nil, err
+		}
+		value = transform(value, segments, replace)
+	}
+	return value, nil
+}
+
+// ParsePath parses a JSON pointer like /a/b/0 or a path like $.a.b[0], a.b[*]
+// or a["some key"] into segments. Segments are glob patterns (see path.Match),
+// ** matches any number of segments.
+func ParsePath(p string) ([]string, error) {
+	var segments []string
+	if strings.HasPrefix(p, "/") {
+		for _, segment := range strings.Split(p[1:], "/") {
+			segments = append(segments, strings.NewReplacer("~1", "/", "~0", "~").Replace(segment))
+		}
+	} else {
+		rest := strings.TrimPrefix(p, "$")
+		if rest != "" && rest[0] != '.' && rest[0] != '[' {
+			rest = "." + rest
+		}
+		for rest != "" {
+			match := segmentRegex.FindStringSubmatch(rest)
+			if match == nil {
+				return nil, fmt.Errorf("invalid path %q at %q", p, rest)
+			}
+			switch {
+			case match[1] != "":
+				segments = append(segments, match[1])
+			case match[2] != "":
+				segments = append(segments, match[2])
+			default:
+				key, err := strconv.Unquote(`"` + match[3] + `"`)
+				if err != nil {
+					return nil, fmt.Errorf("invalid path %q: %w", p, err)
+				}
+				segments = append(segments, key)
+			}
+			rest = rest[len(match[0]):]
+		}
+	}
+
+	if len(segments) == 0 {
+		return nil, fmt.Errorf("invalid path %q: the root can't be redacted or ignored", p)
+	}
+	for _, segment := range segments {
+		if _, err := path.Match(segment, ""); err != nil {
+			return nil, fmt.Errorf("invalid path %q: %w", p, err)
+		}
+	}
+	if segments[len(segments)-1] == "**" {
+		return nil, fmt.Errorf("invalid path %q: can't end with **", p)
+	}
+	return segments, nil
+}
+
+// transform returns a copy of value with replace applied to everything
+// matching segments, value itself is never modified
+func transform(value any, segments []string, replace func(any) (any, bool)) any {
+	if segments[0] == "**" {
+		// ** matching nothing, then descend into every child keeping the **
+		value = transform(value, segments[1:], replace)
+		return mapChildren(value, func(string) bool { return true }, func(child any) (any, bool) {
+			return transform(child, segments, replace), true
+		})
+	}
+
+	matches := func(key string) bool {
+		ok, _ := path.Match(segments[0], key)
+		return ok
+	}
+	if len(segments) == 1 {
+		return mapChildren(value, matches, replace)
+	}
+	return mapChildren(value, matches, func(child any) (any, bool) {
+		return transform(child, segments[1:], replace), true
+	})
+}
+
+// mapChildren returns a copy of an object or list with fn applied to the children
+// whose key/index matches, children are removed if fn returns false
+func mapChildren(value any, matches func(string) bool, fn func(any) (any, bool)) any {
+	switch v := value.(type) {
+	case map[string]any:
+		result := make(map[string]any, len(v))
+		for key, child := range v {
+			if !matches(key) {
+				result[key] = child
+			} else if newChild, keep := fn(child); keep {
+				result[key] = newChild
+			}
+		}
+		return result
+	case []any:
+		result := make([]any, 0, len(v))
+		for i, child := range v {
+			if !matches(strconv.Itoa(i)) {
+				result = append(result, child)
+			} else if newChild, keep := fn(child); keep {
+				result = append(result, newChild)
+			}
+		}
+		return result
+	default:
+		return value
+	}
+}
@@ -0,0 +1,96 @@
+package redact
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func decode(t *testing.T, data string) any {
+	t.Helper()
+	var value any
+	if err := json.Unmarshal([]byte(data), &value); err != nil {
+		t.Fatalf("failed to decode %s: %v", data, err)
+	}
+	return value
+}
+
+func TestParsePath(t *testing.T) {
+	tests := []struct {
+		path    string
+		want    []string
+		wantErr bool
+	}{
+		{"/a/b~1c/0", []string{"a", "b/c", "0"}, false},
+		{"$.a.b[0]", []string{"a", "b", "0"}, false},
+		{"a.*[*]", []string{"a", "*", "*"}, false},
+		{`$["with space"].x`, []string{"with space", "x"}, false},
+		{"**.timestamp", []string{"**", "timestamp"}, false},
+		{"$", nil, true},
+		{"", nil, true},
+		{"a.[", nil, true},
+		{"a.**", nil, true},
+		{"a.[b", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, err := ParsePath(tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParsePath() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParsePath() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedact(t *testing.T) {
+	input := `{"id": "abc", "build": {"time": 123, "hash": "x"}, "items": [{"id": 1, "name": "a"}, {"id": 2, "name": "b"}], "deep": {"nested": {"time": 1}}}`
+	value := decode(t, input)
+
+	got, err := Redact(value, []string{"/id", "build.t*", "items[*].id", "deep.**.time"})
+	if err != nil {
+		t.Fatalf("Redact() unexpected error = %v", err)
+	}
+	want := decode(t, `{"id": "[redacted]", "build": {"time": "[redacted]", "hash": "x"}, "items": [{"id": "[redacted]", "name": "a"}, {"id": "[redacted]", "name": "b"}], "deep": {"nested": {"time": "[redacted]"}}}`)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Redact() = %#v, want %#v", got, want)
+	}
+	if !reflect.DeepEqual(value, decode(t, input)) {
+		t.Errorf("Redact() modified its input")
+	}
+
+	if _, err := Redact(value, []string{"$"}); err == nil {
+		t.Errorf("Redact() expected error for invalid path")
+	}
+}
+
+func TestIgnore(t *testing.T) {
+	value := decode(t, `{"id": "abc", "build": {"time": 123, "hash": "x"}, "items": [1, 2, 3]}`)
+
+	got, err := Ignore(value, []string{"id", "/build/time", "items[1]", "missing.path"})
+	if err != nil {
+		t.Fatalf("Ignore() unexpected error = %v", err)
+	}
+	want := decode(t, `{"build": {"hash": "x"}, "items": [1, 3]}`)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Ignore() = %#v, want %#v", got, want)
+	}
+}
+
+func TestNormalizeStorePaths(t *testing.T) {
+	value := decode(t, `{
+		"out": "/nix/store/0c0w8v1yf3d8kmml2s0wq8chhnq0xjd9-hello-2.12.1/bin/hello",
+		"/nix/store/0c0w8v1yf3d8kmml2s0wq8chhnq0xjd9-key": ["/nix/store/too-short-hash", 1],
+		"text": "deps: /nix/store/aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa-a /nix/store/bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb-b"
+	}`)
+	want := decode(t, `{
+		"out": "/nix/store/<HASH>-hello-2.12.1/bin/hello",
+		"/nix/store/<HASH>-key": ["/nix/store/too-short-hash", 1],
+		"text": "deps: /nix/store/<HASH>-a /nix/store/<HASH>-b"
+	}`)
+	if got := NormalizeStorePaths(value); !reflect.DeepEqual(got, want) {
+		t.Errorf("NormalizeStorePaths() = %#v, want %#v", got, want)
+	}
+}
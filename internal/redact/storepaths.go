@@ -0,0 +1,36 @@
+package redact
+
+import "regexp"
+
+// StoreHashPlaceholder replaces the hash of normalized store paths
+const StoreHashPlaceholder = "<HASH>"
+
+// store path hashes are 32 characters of Nix's base32 alphabet (no e, o, u, t)
+var storePathRegex = regexp.MustCompile(`/nix/store/[0-9a-df-np-sv-z]{32}-`)
+
+// NormalizeStorePaths returns a copy of value with every /nix/store/<hash>-name
+// in strings and object keys rewritten to /nix/store/<HASH>-name
+func NormalizeStorePaths(value any) any {
+	switch v := value.(type) {
+	case string:
+		return normalizeString(v)
+	case map[string]any:
+		result := make(map[string]any, len(v))
+		for key, child := range v {
+			result[normalizeString(key)] = NormalizeStorePaths(child)
+		}
+		return result
+	case []any:
+		result := make([]any, len(v))
+		for i, child := range v {
+			result[i] = NormalizeStorePaths(child)
+		}
+		return result
+	default:
+		return value
+	}
+}
+
+func normalizeString(s string) string {
+	return storePathRegex.ReplaceAllString(s, "/nix/store/"+StoreHashPlaceholder+"-")
+}
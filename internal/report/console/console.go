@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -12,20 +13,29 @@ import (
 	"github.com/rs/zerolog/log"
 	"gitlab.com/TECHNOFAB/nixtest/internal/types"
 	"gitlab.com/TECHNOFAB/nixtest/internal/util"
+	"golang.org/x/sys/unix"
 )
 
-// PrintErrors prints error messages for failed tests
-func PrintErrors(results types.Results, noColor bool) {
+// PrintErrors prints error messages for failed tests, diffs are rendered
+// with diffOpts (side-by-side diffs use the terminal width if no width is set)
+func PrintErrors(results types.Results, diffOpts util.DiffOptions) {
+	if diffOpts.Width == 0 {
+		diffOpts.Width = terminalWidth()
+	}
 	for _, suiteResults := range results {
 		for _, result := range suiteResults {
 			if result.Status == types.StatusSuccess || result.Status == types.StatusSkipped {
 				continue
 			}
-			fmt.Println(text.FgRed.Sprintf("⚠ Test \"%s/%s\" failed:", result.Spec.Suite, result.Spec.Name))
+			header := fmt.Sprintf("⚠ Test \"%s/%s\" failed", result.Spec.Suite, result.Spec.Name)
+			if modes := util.ComparisonModes(result.Spec); modes != "" && result.Status == types.StatusFailure {
+				header += fmt.Sprintf(" (compared with %s)", modes)
+			}
+			fmt.Println(text.FgRed.Sprint(header + ":"))
 			message := result.ErrorMessage
 			if result.Status == types.StatusFailure && message == "" {
 				var err error
-				message, err = util.ComputeDiff(result.Expected, result.Actual)
+				message, err = util.FormatDiff(result, diffOpts)
 				if err != nil {
 					log.Panic().Err(err).Msg("failed to compute diff")
 				}
@@ -38,17 +48,43 @@ func PrintErrors(results types.Results, noColor bool) {
 			for _, line := range strings.Split(strings.TrimRight(message, "\n"), "\n") {
 				fmt.Printf("%s %s\n", text.FgRed.Sprint("|"), line)
 			}
+			if len(result.SubResults) > 0 {
+				fmt.Printf("%s %s\n", text.FgRed.Sprint("|"), text.Bold.Sprint("Sub-results:"))
+				for _, sub := range result.SubResults {
+					fmt.Printf("%s   %s %s\n", text.FgRed.Sprint("|"), statusSymbol(sub.Status), sub.Name)
+					if sub.Message != "" {
+						fmt.Println(util.PrefixLines(strings.TrimRight(sub.Message, "\n"), text.FgRed.Sprint("|")+"       "))
+					}
+				}
+			}
+			if result.WorkDir != "" {
+				fmt.Printf("%s %s %s\n", text.FgRed.Sprint("|"), text.Bold.Sprint("Working directory:"), result.WorkDir)
+			}
+			if result.ArtifactsTmpDir != "" {
+				fmt.Printf("%s %s %s\n", text.FgRed.Sprint("|"), text.Bold.Sprint("Artifacts directory:"), result.ArtifactsTmpDir)
+			}
+			for _, artifact := range result.Artifacts {
+				fmt.Printf("%s %s %s\n", text.FgRed.Sprint("|"), text.Bold.Sprint("Artifact:"), artifact)
+			}
 			fmt.Println()
 		}
 	}
 }
 
-// PrintSummary prints a table summarizing test results
-func PrintSummary(results types.Results, totalSuccessCount int, totalTestCount int) {
+// PrintSummary prints a table summarizing test results.
+// With showStats the CPU time and peak RSS of script tests are shown too.
+func PrintSummary(results types.Results, totalSuccessCount int, totalTestCount int, showStats bool) {
+	row := func(name, duration, cpuTime, peakRSS, status, pos any) table.Row {
+		if showStats {
+			return table.Row{name, duration, cpuTime, peakRSS, status, pos}
+		}
+		return table.Row{name, duration, status, pos}
+	}
+
 	t := table.NewWriter()
 	t.SetStyle(table.StyleLight)
 	t.SetOutputMirror(os.Stdout)
-	t.AppendHeader(table.Row{"Suite / Test", "Duration", "Status", "File:Line"})
+	t.AppendHeader(row("Suite / Test", "Duration", "CPU Time", "Peak RSS", "Status", "File:Line"))
 
 	log.Info().Msg("Summary:")
 
@@ -77,38 +113,31 @@ func PrintSummary(results types.Results, totalSuccessCount int, totalTestCount i
 			statusStr += fmt.Sprintf(" (%d skipped)", suiteSkipped)
 		}
 
-		t.AppendRow(table.Row{
-			text.Bold.Sprint(suiteName),
-			"",
-			statusStr,
-			"",
-		})
+		t.AppendRow(row(text.Bold.Sprint(suiteName), "", "", "", statusStr, ""))
 
 		sort.Slice(suiteResults, func(i, j int) bool {
 			return suiteResults[i].Spec.Name < suiteResults[j].Spec.Name
 		})
 
 		for _, res := range suiteResults {
-			var symbol string
-			switch res.Status {
-			case types.StatusSuccess:
-				symbol = text.FgGreen.Sprint("✅ PASS")
-			case types.StatusFailure:
-				symbol = text.FgRed.Sprint("❌ FAIL")
-			case types.StatusError:
-				symbol = text.FgYellow.Sprint("❗ ERROR")
-			case types.StatusSkipped:
-				symbol = text.FgBlue.Sprint("⏭️ SKIP")
-			default:
-				symbol = "UNKNOWN"
+			var cpuTime, peakRSS string
+			if res.CPUTime > 0 {
+				cpuTime = res.CPUTime.Round(time.Millisecond).String()
 			}
-
-			t.AppendRow([]any{
-				"  " + res.Spec.Name,
+			if res.PeakRSS > 0 {
+				peakRSS = formatBytes(res.PeakRSS)
+			}
+			t.AppendRow(row(
+				"  "+res.Spec.Name,
 				fmt.Sprintf("%s", res.Duration.Round(time.Millisecond)),
-				symbol,
+				cpuTime,
+				peakRSS,
+				statusSymbol(res.Status),
 				res.Spec.Pos,
-			})
+			))
+			for _, sub := range res.SubResults {
+				t.AppendRow(row("    ↳ "+sub.Name, "", "", "", statusSymbol(sub.Status), ""))
+			}
 		}
 		t.AppendSeparator()
 	}
@@ -126,11 +155,50 @@ func PrintSummary(results types.Results, totalSuccessCount int, totalTestCount i
 		overallStatusStr += fmt.Sprintf(" (%d skipped)", totalSkipped)
 	}
 
-	t.AppendFooter(table.Row{
-		text.Bold.Sprint("TOTAL"),
-		"",
-		text.Bold.Sprint(overallStatusStr),
-		"",
-	})
+	t.AppendFooter(row(text.Bold.Sprint("TOTAL"), "", "", "", text.Bold.Sprint(overallStatusStr), ""))
 	t.Render()
 }
+
+func statusSymbol(status types.TestStatus) string {
+	switch status {
+	case types.StatusSuccess:
+		return text.FgGreen.Sprint("✅ PASS")
+	case types.StatusFailure:
+		return text.FgRed.Sprint("❌ FAIL")
+	case types.StatusError:
+		return text.FgYellow.Sprint("❗ ERROR")
+	case types.StatusSkipped:
+		return text.FgBlue.Sprint("⏭️ SKIP")
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// terminalWidth returns the width of the terminal stdout is connected to,
+// $COLUMNS or 0 if unknown. The "| " prefix of PrintErrors is subtracted
+func terminalWidth() int {
+	width := 0
+	if ws, err := unix.IoctlGetWinsize(int(os.Stdout.Fd()), unix.TIOCGWINSZ); err == nil {
+		width = int(ws.Col)
+	} else if columns, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil {
+		width = columns
+	}
+	if width <= 2 {
+		return 0
+	}
+	return width - 2
+}
+
+// formatBytes formats a byte count using binary units, like "12.3 MiB"
+func formatBytes(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
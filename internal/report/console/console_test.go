@@ -13,6 +13,7 @@ import (
 
 	"github.com/jedib0t/go-pretty/v6/text"
 	"gitlab.com/TECHNOFAB/nixtest/internal/types"
+	"gitlab.com/TECHNOFAB/nixtest/internal/util"
 )
 
 // captureOutput captures stdout and stderr during the execution of a function
@@ -78,7 +79,7 @@ func TestPrintErrorsColor(t *testing.T) {
 		},
 	}
 	stdout, _ := captureOutput(func() {
-		PrintErrors(results, false)
+		PrintErrors(results, util.DiffOptions{Color: true, Context: util.DefaultDiffContext})
 	})
 
 	ansiEscapePattern := `(?:\\x1b\[[0-9;]*m)*`
@@ -112,15 +113,28 @@ func TestPrintErrors(t *testing.T) {
 				Expected: "line1\nline2 expected\nline3",
 				Actual:   "line1\nline2 actual\nline3",
 			},
+			{
+				Spec:       types.TestSpec{Suite: "Suite1", Name: "TestFailure_Mismatches", Comparison: []types.Comparison{types.CompareIgnoreCase}},
+				Status:     types.StatusFailure,
+				Expected:   "{\n  \"a\": 1\n}",
+				Actual:     "{\n  \"a\": 2\n}",
+				Mismatches: []types.Mismatch{{Path: "$.a", Message: "expected 1, got 2"}},
+			},
 			{
 				Spec:         types.TestSpec{Suite: "Suite1", Name: "TestFailure_Message"},
 				Status:       types.StatusFailure,
 				ErrorMessage: "This is a specific failure message.\nWith multiple lines.",
+				SubResults: []types.SubResult{
+					{Name: "first assertion", Status: types.StatusSuccess},
+					{Name: "second assertion", Status: types.StatusFailure, Message: "expected 1, got 2"},
+				},
 			},
 			{
-				Spec:         types.TestSpec{Suite: "Suite1", Name: "TestError"},
-				Status:       types.StatusError,
-				ErrorMessage: "System error occurred.",
+				Spec:            types.TestSpec{Suite: "Suite1", Name: "TestError"},
+				Status:          types.StatusError,
+				ErrorMessage:    "System error occurred.",
+				WorkDir:         "/tmp/nixtest-script-123",
+				ArtifactsTmpDir: "/tmp/nixtest-artifacts-123",
 			},
 			{
 				Spec:         types.TestSpec{Suite: "Suite1", Name: "TestEmpty"},
@@ -131,7 +145,7 @@ func TestPrintErrors(t *testing.T) {
 	}
 
 	stdout, _ := captureOutput(func() {
-		PrintErrors(results, true)
+		PrintErrors(results, util.DiffOptions{Context: util.DefaultDiffContext})
 	})
 
 	if strings.Contains(stdout, "TestSuccess") {
@@ -143,7 +157,9 @@ func TestPrintErrors(t *testing.T) {
 		`\|\s*@@ -\d+,\d+ \+\d+,\d+ @@\s*\n` + // matches "| @@ <hunk info> @@"
 		`\|\s* line1\s*\n` + // matches "|  line1" (note the leading space for an "equal" line)
 		`\|\s*-line2 expected\s*\n` + // matches "| -line2 expected"
+		`\|\s*\?\s+\^{8}\s*\n` + // matches the guide line "| ?      ^^^^^^^^"
 		`\|\s*\+line2 actual\s*\n` + // matches "| +line2 actual"
+		`\|\s*\?\s+\^{6}\s*\n` + // matches the guide line "| ?      ^^^^^^"
 		`\|\s* line3\s*` // matches "|  line3"
 
 	matched, _ := regexp.MatchString(expectedDiffPattern, stdout)
@@ -152,6 +168,14 @@ func TestPrintErrors(t *testing.T) {
 		t.Errorf("PrintErrors() TestFailure_Diff diff output mismatch or missing.\nExpected pattern:\n%s\nGot:\n%s", expectedDiffPattern, stdout)
 	}
 
+	if !strings.Contains(stdout, "⚠ Test \"Suite1/TestFailure_Mismatches\" failed (compared with ignoreCase):\n") {
+		t.Errorf("PrintErrors() TestFailure_Mismatches header should show the comparison modes. Output:\n%s", stdout)
+	}
+
+	if !strings.Contains(stdout, "| ~ $.a: expected 1, got 2\n\n") {
+		t.Errorf("PrintErrors() TestFailure_Mismatches should only show the structural diff. Output:\n%s", stdout)
+	}
+
 	if !strings.Contains(stdout, "⚠ Test \"Suite1/TestFailure_Message\" failed:") {
 		t.Errorf("PrintErrors() missing header for TestFailure_Message. Output:\n%s", stdout)
 	}
@@ -162,6 +186,12 @@ func TestPrintErrors(t *testing.T) {
 		t.Errorf("PrintErrors() TestFailure_Message message output mismatch or missing. Output:\n%s", stdout)
 	}
 
+	if !strings.Contains(stdout, "|   ✅ PASS first assertion") ||
+		!strings.Contains(stdout, "|   ❌ FAIL second assertion") ||
+		!strings.Contains(stdout, "|       expected 1, got 2") {
+		t.Errorf("PrintErrors() TestFailure_Message sub-results missing. Output:\n%s", stdout)
+	}
+
 	if !strings.Contains(stdout, "⚠ Test \"Suite1/TestError\" failed:") {
 		t.Errorf("PrintErrors() missing header for TestError. Output:\n%s", stdout)
 	}
@@ -169,6 +199,12 @@ func TestPrintErrors(t *testing.T) {
 	if !strings.Contains(stdout, "| System error occurred.") {
 		t.Errorf("PrintErrors() TestError message output mismatch or missing. Output:\n%s", stdout)
 	}
+	if !strings.Contains(stdout, "| Working directory: /tmp/nixtest-script-123") {
+		t.Errorf("PrintErrors() TestError missing working directory. Output:\n%s", stdout)
+	}
+	if !strings.Contains(stdout, "| Artifacts directory: /tmp/nixtest-artifacts-123") {
+		t.Errorf("PrintErrors() TestError missing artifacts directory. Output:\n%s", stdout)
+	}
 	if !strings.Contains(stdout, "- no output -") {
 		t.Errorf("PrintErrors() missing '- no output -'. Output:\n%s", stdout)
 	}
@@ -181,7 +217,9 @@ func TestPrintSummary(t *testing.T) {
 	results := types.Results{
 		"AlphaSuite": []types.TestResult{
 			{Spec: types.TestSpec{Suite: "AlphaSuite", Name: "TestA", Pos: "alpha.nix:1"}, Status: types.StatusSuccess, Duration: 100 * time.Millisecond},
-			{Spec: types.TestSpec{Suite: "AlphaSuite", Name: "TestB", Pos: "alpha.nix:2"}, Status: types.StatusFailure, Duration: 200 * time.Millisecond},
+			{Spec: types.TestSpec{Suite: "AlphaSuite", Name: "TestB", Pos: "alpha.nix:2"}, Status: types.StatusFailure, Duration: 200 * time.Millisecond, SubResults: []types.SubResult{
+				{Name: "SubTestB1", Status: types.StatusFailure},
+			}},
 		},
 		"BetaSuite": []types.TestResult{
 			{Spec: types.TestSpec{Suite: "BetaSuite", Name: "TestC", Pos: "beta.nix:1"}, Status: types.StatusSkipped, Duration: 50 * time.Millisecond},
@@ -196,7 +234,7 @@ func TestPrintSummary(t *testing.T) {
 	originalStdout := os.Stdout
 	os.Stdout = w
 
-	PrintSummary(results, totalSuccessCount, totalTestCount)
+	PrintSummary(results, totalSuccessCount, totalTestCount, false)
 
 	w.Close()
 	os.Stdout = originalStdout
@@ -216,6 +254,12 @@ func TestPrintSummary(t *testing.T) {
 	if !strings.Contains(stdout, "TestB") || !strings.Contains(stdout, "FAIL") {
 		t.Errorf("PrintSummary() missing TestB or its FAIL status. Output:\n%s", stdout)
 	}
+	if strings.Contains(stdout, "PEAK RSS") {
+		t.Errorf("PrintSummary() should not show stats columns without showStats. Output:\n%s", stdout)
+	}
+	if !strings.Contains(stdout, "↳ SubTestB1") {
+		t.Errorf("PrintSummary() missing sub-result SubTestB1. Output:\n%s", stdout)
+	}
 	if !strings.Contains(stdout, "TestE") || !strings.Contains(stdout, "UNKNOWN") {
 		t.Errorf("PrintSummary() missing TestE or its UNKNOWN status. Output:\n%s", stdout)
 	}
@@ -226,3 +270,46 @@ func TestPrintSummary(t *testing.T) {
 		t.Errorf("PrintSummary() total summary incorrect. Expected to contain '%s'. Output:\n%s", expectedTotalSummary, stdout)
 	}
 }
+
+func TestPrintSummary_Stats(t *testing.T) {
+	text.DisableColors()
+	defer text.EnableColors()
+
+	results := types.Results{
+		"Suite": []types.TestResult{
+			{
+				Spec:     types.TestSpec{Suite: "Suite", Name: "Script"},
+				Status:   types.StatusSuccess,
+				Duration: time.Second,
+				CPUTime:  1500 * time.Millisecond,
+				PeakRSS:  3 * 1024 * 1024,
+			},
+		},
+	}
+
+	stdout, _ := captureOutput(func() {
+		PrintSummary(results, 1, 1, true)
+	})
+
+	for _, want := range []string{"CPU TIME", "PEAK RSS", "1.5s", "3.0 MiB"} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("PrintSummary() with stats missing %q. Output:\n%s", want, stdout)
+		}
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := []struct {
+		bytes int64
+		want  string
+	}{
+		{512, "512 B"},
+		{2048, "2.0 KiB"},
+		{5 * 1024 * 1024 * 1024, "5.0 GiB"},
+	}
+	for _, tt := range tests {
+		if got := formatBytes(tt.bytes); got != tt.want {
+			t.Errorf("formatBytes(%d) = %q, want %q", tt.bytes, got, tt.want)
+		}
+	}
+}
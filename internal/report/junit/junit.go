@@ -7,6 +7,7 @@ import (
 	"strings"
 	"time"
 
+	"gitlab.com/TECHNOFAB/nixtest/internal/subresults"
 	"gitlab.com/TECHNOFAB/nixtest/internal/types"
 	"gitlab.com/TECHNOFAB/nixtest/internal/util"
 )
@@ -34,15 +35,28 @@ type JUnitTestSuite struct {
 }
 
 type JUnitCase struct {
-	XMLName   xml.Name      `xml:"testcase"`
-	Name      string        `xml:"name,attr"`
-	Classname string        `xml:"classname,attr"`
-	Time      string        `xml:"time,attr"`
-	File      string        `xml:"file,attr,omitempty"`
-	Line      string        `xml:"line,attr,omitempty"`
-	Failure   *JUnitFailure `xml:"failure,omitempty"`
-	Error     *JUnitError   `xml:"error,omitempty"`
-	Skipped   *JUnitSkipped `xml:"skipped,omitempty"`
+	XMLName    xml.Name         `xml:"testcase"`
+	Name       string           `xml:"name,attr"`
+	Classname  string           `xml:"classname,attr"`
+	Time       string           `xml:"time,attr"`
+	File       string           `xml:"file,attr,omitempty"`
+	Line       string           `xml:"line,attr,omitempty"`
+	Properties *JUnitProperties `xml:"properties,omitempty"`
+	Failure    *JUnitFailure    `xml:"failure,omitempty"`
+	Error      *JUnitError      `xml:"error,omitempty"`
+	Skipped    *JUnitSkipped    `xml:"skipped,omitempty"`
+	SystemOut  string           `xml:"system-out,omitempty"`
+}
+
+type JUnitProperties struct {
+	XMLName    xml.Name        `xml:"properties"`
+	Properties []JUnitProperty `xml:"property"`
+}
+
+type JUnitProperty struct {
+	XMLName xml.Name `xml:"property"`
+	Name    string   `xml:"name,attr"`
+	Value   string   `xml:"value,attr"`
 }
 
 type JUnitFailure struct {
@@ -97,22 +111,56 @@ func GenerateReport(reportName string, results types.Results) (string, error) {
 				}
 			}
 
+			var properties []JUnitProperty
+			if result.WorkDir != "" {
+				properties = append(properties, JUnitProperty{Name: "workdir", Value: result.WorkDir})
+			}
+			if result.ArtifactsTmpDir != "" {
+				properties = append(properties, JUnitProperty{Name: "artifactsdir", Value: result.ArtifactsTmpDir})
+			}
+			if len(properties) > 0 {
+				testCase.Properties = &JUnitProperties{Properties: properties}
+			}
+
+			// GitLab renders attachments referenced like this in system-out
+			var attachments []string
+			for _, artifact := range result.Artifacts {
+				attachments = append(attachments, fmt.Sprintf("[[ATTACHMENT|%s]]", artifact))
+			}
+			testCase.SystemOut = strings.Join(attachments, "\n")
+
+			// failing sub-results are reported as failed test cases below, so
+			// the parent only carries the details to not count the failure twice
+			subFailed := subresults.CountFailed(result.SubResults) > 0
+
 			switch result.Status {
 			case types.StatusFailure:
-				suite.Failures++
-				report.Failures++
 				var failureContent string
 				if result.ErrorMessage != "" {
 					failureContent = result.ErrorMessage
 				} else {
 					var err error
-					failureContent, err = util.ComputeDiff(result.Expected, result.Actual)
+					failureContent, err = util.FormatDiff(result, util.DiffOptions{Context: util.DefaultDiffContext})
 					if err != nil {
 						return "", fmt.Errorf("failed to compute diff")
 					}
 				}
-				testCase.Failure = &JUnitFailure{Message: "Test failed", Data: failureContent}
+				if subFailed {
+					testCase.SystemOut = joinNonEmpty(failureContent, testCase.SystemOut)
+					break
+				}
+				suite.Failures++
+				report.Failures++
+				message := "Test failed"
+				if modes := util.ComparisonModes(result.Spec); modes != "" {
+					message += fmt.Sprintf(" (compared with %s)", modes)
+				}
+				testCase.Failure = &JUnitFailure{Message: message, Data: failureContent}
 			case types.StatusError:
+				if subFailed {
+					testCase.SystemOut = joinNonEmpty(result.ErrorMessage, testCase.SystemOut)
+					break
+				}
 				suite.Errors++
 				report.Errors++
 				testCase.Error = &JUnitError{Message: "Test errored", Data: result.ErrorMessage}
@@ -123,6 +171,34 @@ func GenerateReport(reportName string, results types.Results) (string, error) {
 			}
 			report.Tests++
 			suite.TestCases = append(suite.TestCases, testCase)
+
+			// sub-results are reported as separate test cases, grouped by the parent test
+			for _, sub := range result.SubResults {
+				subCase := JUnitCase{
+					Name:      result.Spec.Name + " / " + sub.Name,
+					Classname: suiteName + "." + result.Spec.Name,
+					Time:      "0.000",
+					File:      testCase.File,
+					Line:      testCase.Line,
+				}
+				switch sub.Status {
+				case types.StatusFailure:
+					suite.Failures++
+					report.Failures++
+					subCase.Failure = &JUnitFailure{Message: "Sub-test failed", Data: sub.Message}
+				case types.StatusError:
+					suite.Errors++
+					report.Errors++
+					subCase.Error = &JUnitError{Message: "Sub-test errored", Data: sub.Message}
+				case types.StatusSkipped:
+					suite.Skipped++
+					report.Skipped++
+					subCase.Skipped = &JUnitSkipped{Message: sub.Message}
+				}
+				suite.Tests++
+				report.Tests++
+				suite.TestCases = append(suite.TestCases, subCase)
+			}
 		}
 		suite.Time = fmt.Sprintf("%.3f", suiteDuration.Seconds())
 		report.Suites = append(report.Suites, suite)
@@ -137,6 +213,16 @@ func GenerateReport(reportName string, results types.Results) (string, error) {
 	return xml.Header + string(output), nil
 }
 
+func joinNonEmpty(parts ...string) string {
+	var kept []string
+	for _, part := range parts {
+		if part != "" {
+			kept = append(kept, part)
+		}
+	}
+	return strings.Join(kept, "\n")
+}
+
 // WriteFile generates a Junit report and writes it to the specified path
 func WriteFile(filePath string, reportName string, results types.Results) error {
 	xmlContent, err := GenerateReport(reportName, results)
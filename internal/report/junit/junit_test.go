@@ -30,20 +30,29 @@ func TestGenerateReport(t *testing.T) {
 				Duration: 234 * time.Millisecond,
 				Expected: "hello",
 				Actual:   "world",
+				Artifacts: []string{
+					"artifacts/Suite1/Test2_Failure/screenshot.png",
+				},
 			},
 		},
 		"Suite2": []types.TestResult{
 			{
-				Spec:         types.TestSpec{Name: "Test3_Error", Suite: "Suite2"},
-				Status:       types.StatusError,
-				Duration:     345 * time.Millisecond,
-				ErrorMessage: "Something went very wrong",
+				Spec:            types.TestSpec{Name: "Test3_Error", Suite: "Suite2"},
+				Status:          types.StatusError,
+				Duration:        345 * time.Millisecond,
+				ErrorMessage:    "Something went very wrong",
+				WorkDir:         "/tmp/nixtest-script-123",
+				ArtifactsTmpDir: "/tmp/nixtest-artifacts-123",
 			},
 			{
 				Spec:         types.TestSpec{Name: "Test4_Failure_Message", Suite: "Suite2"},
 				Status:       types.StatusFailure,
 				Duration:     456 * time.Millisecond,
 				ErrorMessage: "hello world",
+				SubResults: []types.SubResult{
+					{Name: "sub ok", Status: types.StatusSuccess},
+					{Name: "sub fail", Status: types.StatusFailure, Message: "sub failure message"},
+				},
 			},
 			{
 				Spec:     types.TestSpec{Name: "Test5_Skipped", Suite: "Suite2"},
@@ -66,12 +75,31 @@ func TestGenerateReport(t *testing.T) {
 	if !strings.Contains(xmlString, "<testsuites name=\"MyNixtestReport\"") {
 		t.Errorf("GenerateReport() missing root <testsuites>. Got: %s", xmlString)
 	}
-	if !strings.Contains(xmlString, "tests=\"5\"") {
+	if !strings.Contains(xmlString, `<testcase name="Test4_Failure_Message / sub fail" classname="Suite2.Test4_Failure_Message"`) {
+		t.Errorf("GenerateReport() missing sub-result test case. Got: %s", xmlString)
+	}
+	if !strings.Contains(xmlString, "sub failure message") {
+		t.Errorf("GenerateReport() missing sub-result failure message. Got: %s", xmlString)
+	}
+	if !strings.Contains(xmlString, "tests=\"7\"") {
 		t.Errorf("GenerateReport() incorrect total tests count. Got: %s", xmlString)
 	}
+	// Test4 failed because of its sub-result, which shouldn't be counted twice
+	if !strings.Contains(xmlString, "<system-out>hello world</system-out>") {
+		t.Errorf("GenerateReport() missing parent failure details. Got: %s", xmlString)
+	}
 	if !strings.Contains(xmlString, "failures=\"2\"") {
 		t.Errorf("GenerateReport() incorrect total failures count. Got: %s", xmlString)
 	}
+	if !strings.Contains(xmlString, `<property name="workdir" value="/tmp/nixtest-script-123"></property>`) {
+		t.Errorf("GenerateReport() missing workdir property. Got: %s", xmlString)
+	}
+	if !strings.Contains(xmlString, `<property name="artifactsdir" value="/tmp/nixtest-artifacts-123"></property>`) {
+		t.Errorf("GenerateReport() missing artifactsdir property. Got: %s", xmlString)
+	}
+	if !strings.Contains(xmlString, "<system-out>[[ATTACHMENT|artifacts/Suite1/Test2_Failure/screenshot.png]]</system-out>") {
+		t.Errorf("GenerateReport() missing artifact attachment. Got: %s", xmlString)
+	}
 	if !strings.Contains(xmlString, "errors=\"1\"") {
 		t.Errorf("GenerateReport() incorrect total errors count. Got: %s", xmlString)
 	}
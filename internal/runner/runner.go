@@ -2,15 +2,25 @@ package runner
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"reflect"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
 	"regexp"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/rs/zerolog/log"
+	"gitlab.com/TECHNOFAB/nixtest/internal/compare"
+	apperrors "gitlab.com/TECHNOFAB/nixtest/internal/errors"
 	"gitlab.com/TECHNOFAB/nixtest/internal/nix"
+	"gitlab.com/TECHNOFAB/nixtest/internal/redact"
 	"gitlab.com/TECHNOFAB/nixtest/internal/snapshot"
+	"gitlab.com/TECHNOFAB/nixtest/internal/subresults"
+	"gitlab.com/TECHNOFAB/nixtest/internal/tree"
 	"gitlab.com/TECHNOFAB/nixtest/internal/types"
 	"gitlab.com/TECHNOFAB/nixtest/internal/util"
 )
@@ -21,6 +31,8 @@ type Runner struct {
 	nixService  nix.Service
 	snapService snapshot.Service
 	skipRegex   *regexp.Regexp
+	verbose     bool
+	liveOut     io.Writer
 	resultsChan chan types.TestResult
 	jobsChan    chan types.TestSpec
 	wg          sync.WaitGroup
@@ -33,13 +45,32 @@ type Config struct {
 	UpdateSnapshots bool
 	SkipPattern     string
 	ImpureEnv       bool
+	// KeepTmp controls when working directories of script tests are kept,
+	// one of KeepTmpNever, KeepTmpFailed or KeepTmpAlways
+	KeepTmp string
+	// ArtifactsDir is where files from $NIXTEST_ARTIFACTS are collected to, empty to discard them
+	ArtifactsDir string
+	// OutputLimit caps the captured stdout/stderr of script tests in bytes, <= 0 means unlimited
+	OutputLimit int
+	// OutputSpillDir is where the full output of truncated script tests is written to, empty to disable
+	OutputSpillDir string
+	// Verbose streams the output of script tests and their nix builds live,
+	// it gets enabled automatically if only a single test is selected
+	Verbose bool
 }
 
+const (
+	KeepTmpNever  = "never"
+	KeepTmpFailed = "failed"
+	KeepTmpAlways = "always"
+)
+
 func New(cfg Config, nixService nix.Service, snapService snapshot.Service) (*Runner, error) {
 	r := &Runner{
 		config:      cfg,
 		nixService:  nixService,
 		snapService: snapService,
+		liveOut:     os.Stderr,
 	}
 	if cfg.SkipPattern != "" {
 		var err error
@@ -48,6 +79,11 @@ func New(cfg Config, nixService nix.Service, snapService snapshot.Service) (*Run
 			return nil, fmt.Errorf("failed to compile skip regex: %w", err)
 		}
 	}
+	switch cfg.KeepTmp {
+	case "", KeepTmpNever, KeepTmpFailed, KeepTmpAlways:
+	default:
+		return nil, fmt.Errorf("invalid keep-tmp mode %q, must be one of %s, %s or %s", cfg.KeepTmp, KeepTmpNever, KeepTmpFailed, KeepTmpAlways)
+	}
 	return r, nil
 }
 
@@ -61,9 +97,16 @@ func (r *Runner) shouldSkip(name string) bool {
 // RunTests executes all tests from the given suites
 func (r *Runner) RunTests(suites []types.SuiteSpec) types.Results {
 	totalTests := 0
+	selectedTests := 0
 	for _, suite := range suites {
 		totalTests += len(suite.Tests)
+		for _, test := range suite.Tests {
+			if !r.shouldSkip(test.Name) {
+				selectedTests++
+			}
+		}
 	}
+	r.verbose = r.config.Verbose || selectedTests == 1
 
 	r.jobsChan = make(chan types.TestSpec, totalTests)
 	r.resultsChan = make(chan types.TestResult, totalTests)
@@ -112,43 +155,104 @@ func (r *Runner) executeTest(spec types.TestSpec) types.TestResult {
 		return result
 	}
 
-	var actual any
-	var err error
-
-	if spec.ActualDrv != "" {
-		actual, err = r.nixService.BuildAndParseJSON(spec.ActualDrv)
-		if err != nil {
-			result.Status = types.StatusError
-			result.ErrorMessage = fmt.Sprintf("[system] failed to build/parse actualDrv %s: %v", spec.ActualDrv, err)
-			goto end
-		}
-	} else {
-		actual = spec.Actual
-	}
-
 	switch spec.Type {
 	case types.TestTypeSnapshot:
-		r.handleSnapshotTest(&result, spec, actual)
+		if actual, ok := r.actualValue(&result, spec); ok {
+			r.handleSnapshotTest(&result, spec, actual)
+		}
 	case types.TestTypeUnit:
-		r.handleUnitTest(&result, spec, actual)
+		if actual, ok := r.actualValue(&result, spec); ok {
+			r.handleUnitTest(&result, spec, actual)
+		}
+	case types.TestTypeEval:
+		r.handleEvalTest(&result, spec)
+	case types.TestTypeTree:
+		r.handleTreeTest(&result, spec)
+	case types.TestTypeThrows:
+		r.handleThrowsTest(&result, spec)
+	case types.TestTypeBuild, types.TestTypeBuildFails:
+		r.handleBuildTest(&result, spec)
 	case types.TestTypeScript:
-		r.handleScriptTest(&result, spec)
+		r.handleScriptTest(&result, spec, spec.Script, r.nixService.BuildAndRunScript)
+	case types.TestTypeVM:
+		r.handleScriptTest(&result, spec, spec.Driver, r.nixService.BuildAndRunVM)
 	default:
 		result.Status = types.StatusError
 		result.ErrorMessage = fmt.Sprintf("Invalid test type: %s", spec.Type)
 	}
 
-end:
 	result.Duration = time.Since(startTime)
 	return result
 }
 
+// actualValue returns spec.Actual or the parsed output of spec.ActualDrv if set
+func (r *Runner) actualValue(result *types.TestResult, spec types.TestSpec) (any, bool) {
+	if spec.ActualDrv == "" {
+		return spec.Actual, true
+	}
+	actual, err := r.nixService.BuildAndParse(spec.ActualDrv, spec.ActualFormat)
+	if err != nil {
+		result.Status = types.StatusError
+		result.ErrorMessage = fmt.Sprintf("[system] failed to build/parse actualDrv %s: %v", spec.ActualDrv, err)
+		return nil, false
+	}
+	return actual, true
+}
+
+// handleEvalTest processes eval type tests, which evaluate the target with nix
+// and compare it like unit tests
+func (r *Runner) handleEvalTest(result *types.TestResult, spec types.TestSpec) {
+	actual, err := r.nixService.Eval(evalTarget(spec))
+	var evalErr *apperrors.NixEvalError
+	if errors.As(err, &evalErr) {
+		// the evaluation failing is the test failing, not nixtest
+		result.Status = types.StatusFailure
+		result.ErrorMessage = fmt.Sprintf("evaluation of %s failed:\n%s", evalErr.Target, strings.TrimSpace(evalErr.Stderr))
+		return
+	} else if err != nil {
+		result.Status = types.StatusError
+		result.ErrorMessage = fmt.Sprintf("[system] failed to evaluate %s: %v", evalTarget(spec), err)
+		return
+	}
+	r.handleUnitTest(result, spec, actual)
+}
+
+// handleTreeTest processes tree type tests, which snapshot the output tree of the derivation
+func (r *Runner) handleTreeTest(result *types.TestResult, spec types.TestSpec) {
+	actual, err := r.buildTree(spec.Derivation)
+	if err != nil {
+		result.Status = types.StatusError
+		result.ErrorMessage = fmt.Sprintf("[system] failed to build/read derivation %s: %v", spec.Derivation, err)
+		return
+	}
+	r.handleSnapshotTest(result, spec, actual)
+}
+
+// buildTree builds the derivation and reads its output tree (see tree.Read)
+func (r *Runner) buildTree(derivation string) (any, error) {
+	path, err := r.nixService.BuildDerivation(derivation)
+	if err != nil {
+		return nil, err
+	}
+	return tree.Read(path)
+}
+
+func evalTarget(spec types.TestSpec) nix.EvalTarget {
+	return nix.EvalTarget{Flake: spec.Flake, File: spec.File, Attr: spec.Attr}
+}
+
 // handleSnapshotTest processes snapshot type tests
 func (r *Runner) handleSnapshotTest(result *types.TestResult, spec types.TestSpec, actual any) {
 	snapPath := r.snapService.GetPath(r.config.SnapshotDir, spec.Name)
 
 	if r.config.UpdateSnapshots {
-		if err := r.snapService.CreateFile(snapPath, actual); err != nil {
+		redacted, err := redactValue(spec, actual)
+		if err != nil {
+			result.Status = types.StatusError
+			result.ErrorMessage = fmt.Sprintf("[system] %v", err)
+			return
+		}
+		if err := r.snapService.CreateFile(snapPath, redacted); err != nil {
 			result.Status = types.StatusError
 			result.ErrorMessage = fmt.Sprintf("[system] failed to update snapshot %s: %v", snapPath, err)
 			return
@@ -170,38 +274,407 @@ func (r *Runner) handleSnapshotTest(result *types.TestResult, spec types.TestSpe
 		return
 	}
 
-	r.compareActualExpected(result, actual, expected)
+	// snapshots contain recorded data, keys like "$regex" in them aren't matchers
+	r.compareActualExpected(result, spec, actual, expected, false)
 }
 
 // handleUnitTest processes unit type tests
 func (r *Runner) handleUnitTest(result *types.TestResult, spec types.TestSpec, actual any) {
 	expected := spec.Expected
-	r.compareActualExpected(result, actual, expected)
+	r.compareActualExpected(result, spec, actual, expected, true)
 }
 
-// handleScriptTest processes script type tests
-func (r *Runner) handleScriptTest(result *types.TestResult, spec types.TestSpec) {
-	exitCode, stdout, stderrStr, err := r.nixService.BuildAndRunScript(spec.Script, r.config.ImpureEnv)
+// handleThrowsTest processes throws type tests, which pass if the evaluation fails
+// with a message matching spec.Contains and spec.Pattern (if set). Failing to
+// resolve the target (e.g. a typo in the attribute) is an error, not a pass.
+func (r *Runner) handleThrowsTest(result *types.TestResult, spec types.TestSpec) {
+	target := evalTarget(spec)
+	value, err := r.nixService.Eval(target)
+	var evalErr *apperrors.NixEvalError
+	if err == nil {
+		valueBytes, _ := json.MarshalIndent(value, "", "  ")
+		result.Status = types.StatusFailure
+		result.ErrorMessage = fmt.Sprintf("expected evaluation of %s to fail, but it returned:\n%s", target, valueBytes)
+		return
+	} else if !errors.As(err, &evalErr) {
+		result.Status = types.StatusError
+		result.ErrorMessage = fmt.Sprintf("[system] failed to evaluate %s: %v", target, err)
+		return
+	}
+
+	message := nix.EvalErrorMessage(evalErr.Stderr)
+	if message == "" {
+		result.Status = types.StatusError
+		result.ErrorMessage = fmt.Sprintf("[system] evaluation of %s failed without an error message: %v", target, evalErr.Err)
+		return
+	}
+	if nix.TargetUnresolved(target, evalErr.Stderr) {
+		result.Status = types.StatusError
+		result.ErrorMessage = fmt.Sprintf("[system] failed to evaluate %s, it can't be resolved: %s", target, message)
+		return
+	}
+	matched, err := matchMessage(spec, message)
 	if err != nil {
 		result.Status = types.StatusError
-		result.ErrorMessage = fmt.Sprintf("[system] failed to run script derivation %s: %v", spec.Script, err)
+		result.ErrorMessage = err.Error()
+	} else if !matched {
+		result.Status = types.StatusFailure
+		result.ErrorMessage = fmt.Sprintf("evaluation of %s failed with an unexpected message, expected one %s, got:\n%s", target, expectedMessage(spec), message)
+	}
+}
+
+// handleBuildTest processes build type tests, which pass if the derivation builds,
+// and buildFails type tests, which pass if its builder fails with a log matching
+// spec.Contains and spec.Pattern (if set). Nix failing to build at all (e.g. a
+// missing derivation or an unreachable daemon) is an error for both.
+func (r *Runner) handleBuildTest(result *types.TestResult, spec types.TestSpec) {
+	path, err := r.nixService.BuildDerivation(spec.Derivation)
+	var buildErr *apperrors.NixBuildError
+	if err != nil && !errors.As(err, &buildErr) {
+		result.Status = types.StatusError
+		result.ErrorMessage = fmt.Sprintf("[system] failed to build derivation %s: %v", spec.Derivation, err)
+		return
+	}
+
+	var failedDrv string
+	if buildErr != nil {
+		var builderFailed bool
+		failedDrv, builderFailed = nix.FailedBuilder(buildErr.Stderr)
+		if !builderFailed {
+			result.Status = types.StatusError
+			result.ErrorMessage = fmt.Sprintf("[system] failed to build derivation %s, no builder ran:\n%s", spec.Derivation, strings.TrimSpace(util.StripANSI(buildErr.Stderr)))
+			return
+		}
+	}
+
+	if spec.Type == types.TestTypeBuild {
+		if buildErr != nil {
+			result.Status = types.StatusFailure
+			result.ErrorMessage = fmt.Sprintf("build of %s failed:\n%s", spec.Derivation, strings.TrimSpace(util.StripANSI(buildErr.Stderr)))
+		}
+		return
+	}
+
+	if buildErr == nil {
+		result.Status = types.StatusFailure
+		result.ErrorMessage = fmt.Sprintf("expected build of %s to fail, but it succeeded with %s", spec.Derivation, path)
 		return
 	}
-	if exitCode != 0 {
+	// nix build only prints the last lines of the log, so match the full one
+	buildLog, err := r.nixService.BuildLog(failedDrv)
+	if err != nil {
+		log.Warn().Err(err).Str("test", spec.Name).Msg("Failed to get the full build log, matching the output of nix build instead")
+		buildLog = buildErr.Stderr
+	}
+	buildLog = strings.TrimSpace(util.StripANSI(buildLog))
+	matched, err := matchMessage(spec, buildLog)
+	if err != nil {
+		result.Status = types.StatusError
+		result.ErrorMessage = err.Error()
+	} else if !matched {
 		result.Status = types.StatusFailure
-		result.ErrorMessage = fmt.Sprintf("[exit code %d]\n[stdout]\n%s\n[stderr]\n%s", exitCode, stdout, stderrStr)
+		result.ErrorMessage = fmt.Sprintf("build of %s failed with an unexpected log, expected one %s, got:\n%s", spec.Derivation, expectedMessage(spec), buildLog)
+	}
+}
+
+// matchMessage checks an error message or log against spec.Contains (substring)
+// and spec.Pattern (regex), both have to match if set
+func matchMessage(spec types.TestSpec, message string) (bool, error) {
+	if spec.Contains != "" && !strings.Contains(message, spec.Contains) {
+		return false, nil
+	}
+	if spec.Pattern != "" {
+		re, err := regexp.Compile(spec.Pattern)
+		if err != nil {
+			return false, fmt.Errorf("[system] invalid pattern %q: %v", spec.Pattern, err)
+		}
+		return re.MatchString(message), nil
+	}
+	return true, nil
+}
+
+// expectedMessage describes what matchMessage expects, shown instead of an expected value
+func expectedMessage(spec types.TestSpec) string {
+	var parts []string
+	if spec.Contains != "" {
+		parts = append(parts, fmt.Sprintf("containing %q", spec.Contains))
+	}
+	if spec.Pattern != "" {
+		parts = append(parts, fmt.Sprintf("matching /%s/", spec.Pattern))
+	}
+	return strings.Join(parts, " and ")
+}
+
+// exitStatus describes how a failed script exited, by signal or exit code
+func exitStatus(scriptResult nix.ScriptResult) string {
+	if scriptResult.Signal != "" {
+		return fmt.Sprintf("[killed by signal: %s]", scriptResult.Signal)
 	}
+	return fmt.Sprintf("[exit code %d]", scriptResult.ExitCode)
 }
 
-// compareActualExpected performs the deep equality check and formats diffs
-func (r *Runner) compareActualExpected(result *types.TestResult, actual, expected any) {
-	if reflect.DeepEqual(actual, expected) {
+// handleScriptTest processes script and vm type tests, using run to build and run the derivation
+func (r *Runner) handleScriptTest(result *types.TestResult, spec types.TestSpec, derivation string, run func(string, nix.ScriptOptions) (nix.ScriptResult, error)) {
+	opts := nix.ScriptOptions{
+		ImpureEnv:   r.config.ImpureEnv,
+		Fixtures:    spec.Fixtures,
+		Interpreter: spec.Interpreter,
+		Entrypoint:  spec.Entrypoint,
+		Args:        spec.Args,
+		Stdin:       spec.Stdin,
+		Limits:      spec.Limits,
+		OutputLimit: r.config.OutputLimit,
+		SpillDir:    r.spillDir(spec),
+	}
+	if r.verbose {
+		prefix := fmt.Sprintf("[%s] ", spec.Name)
+		liveStdout, liveStderr := util.NewPrefixWriter(r.liveOut, prefix), util.NewPrefixWriter(r.liveOut, prefix)
+		opts.LiveStdout, opts.LiveStderr = liveStdout, liveStderr
+		defer flushLive(liveStdout, liveStderr)
+	}
+	scriptResult, err := run(derivation, opts)
+	defer r.cleanupWorkDir(result, scriptResult.WorkDir)
+	defer r.collectArtifacts(result, spec, scriptResult.ArtifactsDir)
+
+	if err != nil {
+		result.Status = types.StatusError
+		result.ErrorMessage = fmt.Sprintf("[system] failed to run %s derivation %s: %v", spec.Type, derivation, err)
+		return
+	}
+
+	subResults, err := subresults.ParseJSONLines(scriptResult.Results)
+	if err != nil {
+		result.Status = types.StatusError
+		result.ErrorMessage = fmt.Sprintf("[system] failed to parse $NIXTEST_RESULTS of %s: %v", derivation, err)
+		return
+	}
+	subResults = append(subResults, scriptResult.SubResults...)
+	result.SubResults = subResults
+	result.PeakRSS = scriptResult.PeakRSS
+	result.CPUTime = scriptResult.CPUTime
+
+	if scriptResult.LimitExceeded != "" {
+		result.Status = types.StatusFailure
+		result.ErrorMessage = fmt.Sprintf("[limit exceeded] %s\n%s\n[stdout]\n%s\n[stderr]\n%s", scriptResult.LimitExceeded, exitStatus(scriptResult), scriptResult.Stdout, scriptResult.Stderr)
+	} else if scriptResult.ExitCode != 0 {
+		result.Status = types.StatusFailure
+		result.ErrorMessage = fmt.Sprintf("%s\n[stdout]\n%s\n[stderr]\n%s", exitStatus(scriptResult), scriptResult.Stdout, scriptResult.Stderr)
+	} else if failed := subresults.CountFailed(subResults); failed > 0 {
+		result.Status = types.StatusFailure
+		result.ErrorMessage = fmt.Sprintf("[%d of %d sub-results failed]\n[stdout]\n%s\n[stderr]\n%s", failed, len(subResults), scriptResult.Stdout, scriptResult.Stderr)
+	}
+
+	for _, spilled := range []struct{ name, file string }{
+		{"stdout", scriptResult.StdoutFile},
+		{"stderr", scriptResult.StderrFile},
+	} {
+		if spilled.file == "" {
+			continue
+		}
+		result.Artifacts = append(result.Artifacts, spilled.file)
+		if result.ErrorMessage != "" {
+			result.ErrorMessage += fmt.Sprintf("\n[full %s in %s]", spilled.name, spilled.file)
+		}
+	}
+}
+
+// flushLive writes out incomplete last lines of the live writers
+func flushLive(writers ...*util.PrefixWriter) {
+	for _, w := range writers {
+		if err := w.Flush(); err != nil {
+			log.Warn().Err(err).Msg("Failed to write live output")
+		}
+	}
+}
+
+// spillDir returns the directory the full output of a script test is spilled to
+func (r *Runner) spillDir(spec types.TestSpec) string {
+	if r.config.OutputSpillDir == "" {
+		return ""
+	}
+	return testDir(r.config.OutputSpillDir, spec)
+}
+
+// testDir returns a per-test directory <base>/<suite>/<test>
+func testDir(base string, spec types.TestSpec) string {
+	return filepath.Join(
+		base,
+		strings.ReplaceAll(spec.Suite, "/", "_"),
+		strings.ReplaceAll(spec.Name, "/", "_"),
+	)
+}
+
+// keepTmp reports whether the temporary directories of a test should be kept
+// according to the keep-tmp mode
+func (r *Runner) keepTmp(result *types.TestResult) bool {
+	failed := result.Status == types.StatusFailure || result.Status == types.StatusError
+	return r.config.KeepTmp == KeepTmpAlways || (r.config.KeepTmp == KeepTmpFailed && failed)
+}
+
+// cleanupWorkDir removes the working directory of a script test, unless
+// it should be kept according to the keep-tmp mode
+func (r *Runner) cleanupWorkDir(result *types.TestResult, dir string) {
+	if dir == "" {
+		return
+	}
+	if r.keepTmp(result) {
+		result.WorkDir = dir
+		return
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		log.Warn().Err(err).Str("dir", dir).Msg("Failed to remove working directory")
+	}
+}
+
+// collectArtifacts copies everything a script wrote to $NIXTEST_ARTIFACTS
+// into <ArtifactsDir>/<suite>/<test>/ and removes the temporary directory,
+// unless it should be kept according to the keep-tmp mode
+func (r *Runner) collectArtifacts(result *types.TestResult, spec types.TestSpec, dir string) {
+	if dir == "" {
+		return
+	}
+	defer func() {
+		if r.keepTmp(result) {
+			result.ArtifactsTmpDir = dir
+			return
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			log.Warn().Err(err).Str("dir", dir).Msg("Failed to remove artifacts directory")
+		}
+	}()
+	if r.config.ArtifactsDir == "" {
+		return
+	}
+
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			files = append(files, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Warn().Err(err).Str("test", spec.Name).Msg("Failed to list artifacts")
+		return
+	}
+	if len(files) == 0 {
+		return
+	}
+
+	target := testDir(r.config.ArtifactsDir, spec)
+	if err := os.MkdirAll(target, 0755); err != nil {
+		log.Warn().Err(err).Str("test", spec.Name).Msg("Failed to create artifacts directory")
+		return
+	}
+	if err := util.CopyDir(dir, target); err != nil {
+		log.Warn().Err(err).Str("test", spec.Name).Msg("Failed to collect artifacts")
+		return
+	}
+
+	for _, file := range files {
+		result.Artifacts = append(result.Artifacts, filepath.Join(target, file))
+	}
+}
+
+// redactValue removes the spec's ignored paths from value, replaces the
+// redacted ones and normalizes numbers and store paths according to the spec
+func redactValue(spec types.TestSpec, value any) (any, error) {
+	switch spec.Numbers {
+	case "", types.NumbersFloat:
+		value = util.FloatNumbers(value)
+	case types.NumbersExact:
+	default:
+		return nil, fmt.Errorf("invalid numbers mode %q, must be one of %s or %s", spec.Numbers, types.NumbersFloat, types.NumbersExact)
+	}
+	switch spec.StorePaths {
+	case "", types.StorePathsKeep:
+	case types.StorePathsNormalize:
+		value = redact.NormalizeStorePaths(value)
+	default:
+		return nil, fmt.Errorf("invalid storePaths mode %q, must be one of %s or %s", spec.StorePaths, types.StorePathsKeep, types.StorePathsNormalize)
+	}
+	value, err := redact.Ignore(value, spec.Ignore)
+	if err != nil {
+		return nil, err
+	}
+	return redact.Redact(value, spec.Redact)
+}
+
+// compareActualExpected compares actual against expected (which may contain
+// matchers if enabled) according to the spec's match mode and comparisons and
+// formats diffs. Ignored and redacted paths and store path normalization are
+// applied to both first.
+func (r *Runner) compareActualExpected(result *types.TestResult, spec types.TestSpec, actual, expected any, matchers bool) {
+	opts := compare.Options{Matchers: matchers, Tolerance: compare.Tolerance(spec.Tolerance)}
+	if opts.Tolerance.Absolute < 0 || opts.Tolerance.Relative < 0 {
+		result.Status = types.StatusError
+		result.ErrorMessage = "[system] tolerance must not be negative"
+		return
+	}
+	switch spec.Match {
+	case "", types.MatchExact:
+	case types.MatchSubset:
+		opts.Subset = true
+		opts.SubsetLists = spec.SubsetLists
+	default:
+		result.Status = types.StatusError
+		result.ErrorMessage = fmt.Sprintf("[system] invalid match mode %q, must be one of %s or %s", spec.Match, types.MatchExact, types.MatchSubset)
+		return
+	}
+	for _, comparison := range spec.Comparison {
+		switch comparison {
+		case types.CompareIgnoreWhitespace:
+			opts.IgnoreWhitespace = true
+		case types.CompareIgnoreCase:
+			opts.IgnoreCase = true
+		case types.CompareIgnoreTrailingNewline:
+			opts.IgnoreTrailingNewline = true
+		case types.CompareUnorderedLists:
+			opts.UnorderedLists = true
+		default:
+			result.Status = types.StatusError
+			result.ErrorMessage = fmt.Sprintf("[system] invalid comparison %q", comparison)
+			return
+		}
+	}
+
+	expected, err := redactValue(spec, expected)
+	if err == nil {
+		actual, err = redactValue(spec, actual)
+	}
+	if err != nil {
+		result.Status = types.StatusError
+		result.ErrorMessage = fmt.Sprintf("[system] %v", err)
+		return
+	}
+
+	compared, err := compare.Compare(expected, actual, opts)
+	if err != nil {
+		result.Status = types.StatusError
+		result.ErrorMessage = fmt.Sprintf("[system] %v", err)
+		return
+	}
+
+	if len(compared.Mismatches) == 0 {
 		// if we already have an error don't overwrite it
 		if result.Status != types.StatusError {
 			result.Status = types.StatusSuccess
 		}
 	} else {
 		result.Status = types.StatusFailure
+		result.Mismatches = compared.Mismatches
+		// satisfied matchers are replaced by the actual values and in subset
+		// mode actual only contains what expected describes, so the diff only
+		// shows the real differences
+		expected = compared.Expected
+		actual = compared.Actual
 
 		var actualStr, expectedStr string
 		var marshalErr error
@@ -1,15 +1,19 @@
 package runner
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
 
 	apperrors "gitlab.com/TECHNOFAB/nixtest/internal/errors"
+	"gitlab.com/TECHNOFAB/nixtest/internal/nix"
 	"gitlab.com/TECHNOFAB/nixtest/internal/types"
 )
 
@@ -17,8 +21,11 @@ import (
 
 type mockNixService struct {
 	BuildDerivationFunc   func(derivation string) (string, error)
-	BuildAndParseJSONFunc func(derivation string) (any, error)
-	BuildAndRunScriptFunc func(derivation string, impureEnv bool) (exitCode int, stdout string, stderr string, err error)
+	BuildLogFunc          func(derivation string) (string, error)
+	BuildAndParseFunc     func(derivation string, format types.Format) (any, error)
+	BuildAndRunScriptFunc func(derivation string, opts nix.ScriptOptions) (nix.ScriptResult, error)
+	BuildAndRunVMFunc     func(driver string, opts nix.ScriptOptions) (nix.ScriptResult, error)
+	EvalFunc              func(target nix.EvalTarget) (any, error)
 }
 
 func (m *mockNixService) BuildDerivation(d string) (string, error) {
@@ -27,17 +34,36 @@ func (m *mockNixService) BuildDerivation(d string) (string, error) {
 	}
 	return m.BuildDerivationFunc(d)
 }
-func (m *mockNixService) BuildAndParseJSON(d string) (any, error) {
-	if m.BuildAndParseJSONFunc == nil {
-		panic("mockNixService.BuildAndParseJSONFunc not set")
+func (m *mockNixService) BuildLog(d string) (string, error) {
+	if m.BuildLogFunc == nil {
+		panic("mockNixService.BuildLogFunc not set")
 	}
-	return m.BuildAndParseJSONFunc(d)
+	return m.BuildLogFunc(d)
 }
-func (m *mockNixService) BuildAndRunScript(d string, p bool) (int, string, string, error) {
+func (m *mockNixService) BuildAndParse(d string, f types.Format) (any, error) {
+	if m.BuildAndParseFunc == nil {
+		panic("mockNixService.BuildAndParseFunc not set")
+	}
+	return m.BuildAndParseFunc(d, f)
+}
+func (m *mockNixService) BuildAndRunScript(d string, o nix.ScriptOptions) (nix.ScriptResult, error) {
 	if m.BuildAndRunScriptFunc == nil {
 		panic("mockNixService.BuildAndRunScriptFunc not set")
 	}
-	return m.BuildAndRunScriptFunc(d, p)
+	return m.BuildAndRunScriptFunc(d, o)
+}
+func (m *mockNixService) BuildAndRunVM(d string, o nix.ScriptOptions) (nix.ScriptResult, error) {
+	if m.BuildAndRunVMFunc == nil {
+		panic("mockNixService.BuildAndRunVMFunc not set")
+	}
+	return m.BuildAndRunVMFunc(d, o)
+}
+
+func (m *mockNixService) Eval(target nix.EvalTarget) (any, error) {
+	if m.EvalFunc == nil {
+		panic("mockNixService.EvalFunc not set")
+	}
+	return m.EvalFunc(target)
 }
 
 type mockSnapshotService struct {
@@ -102,6 +128,8 @@ func TestNewRunner(t *testing.T) {
 		{"Valid config, no skip", Config{NumWorkers: 1}, false, ""},
 		{"Valid config, valid skip", Config{NumWorkers: 1, SkipPattern: "Test.*"}, false, "Test.*"},
 		{"Invalid skip pattern", Config{NumWorkers: 1, SkipPattern: "[invalid"}, true, ""},
+		{"Valid keep-tmp mode", Config{NumWorkers: 1, KeepTmp: KeepTmpFailed}, false, ""},
+		{"Invalid keep-tmp mode", Config{NumWorkers: 1, KeepTmp: "sometimes"}, true, ""},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -172,12 +200,129 @@ func TestRunner_executeTest(t *testing.T) {
 			wantExpected:      "{\n  \"a\": 1\n}",
 			wantActual:        "{\n  \"a\": 2\n}",
 		},
+		{
+			name:              "Unit test success (matchers)",
+			spec:              types.TestSpec{Name: "UnitMatchers", Type: types.TestTypeUnit, Expected: map[string]any{"id": map[string]any{"$regex": "^[0-9a-f]+$"}, "n": 1.0}, Actual: map[string]any{"id": "abc123", "n": 1.0}},
+			runnerConfig:      Config{},
+			setupMockServices: func(t *testing.T, mNix *mockNixService, mSnap *mockSnapshotService, s types.TestSpec, c Config) {},
+			wantStatus:        types.StatusSuccess,
+		},
+		{
+			name:              "Unit test failure (matchers)",
+			spec:              types.TestSpec{Name: "UnitMatchersFail", Type: types.TestTypeUnit, Expected: map[string]any{"id": map[string]any{"$regex": "^[0-9a-f]+$"}, "n": map[string]any{"$any": true}}, Actual: map[string]any{"id": "xyz", "n": 2.0}},
+			runnerConfig:      Config{},
+			setupMockServices: func(t *testing.T, mNix *mockNixService, mSnap *mockSnapshotService, s types.TestSpec, c Config) {},
+			wantStatus:        types.StatusFailure,
+			// satisfied matchers are replaced by the actual value
+			wantExpected: "{\n  \"id\": {\n    \"$regex\": \"^[0-9a-f]+$\"\n  },\n  \"n\": 2\n}",
+			wantActual:   "{\n  \"id\": \"xyz\",\n  \"n\": 2\n}",
+		},
+		{
+			name:              "Unit test failure (subset)",
+			spec:              types.TestSpec{Name: "UnitSubset", Type: types.TestTypeUnit, Match: types.MatchSubset, Expected: map[string]any{"a": 1.0, "b": 2.0}, Actual: map[string]any{"a": 1.0, "b": 3.0, "c": 4.0}},
+			runnerConfig:      Config{},
+			setupMockServices: func(t *testing.T, mNix *mockNixService, mSnap *mockSnapshotService, s types.TestSpec, c Config) {},
+			wantStatus:        types.StatusFailure,
+			// extra keys in actual are not part of the diff
+			wantExpected: "{\n  \"a\": 1,\n  \"b\": 2\n}",
+			wantActual:   "{\n  \"a\": 1,\n  \"b\": 3\n}",
+		},
+		{
+			name:               "Unit test error (invalid match mode)",
+			spec:               types.TestSpec{Name: "UnitMatchInvalid", Type: types.TestTypeUnit, Match: "superset", Expected: 1.0, Actual: 1.0},
+			runnerConfig:       Config{},
+			setupMockServices:  func(t *testing.T, mNix *mockNixService, mSnap *mockSnapshotService, s types.TestSpec, c Config) {},
+			wantStatus:         types.StatusError,
+			wantErrMsgContains: "invalid match mode",
+		},
+		{
+			name:              "Unit test success (redacted and ignored paths)",
+			spec:              types.TestSpec{Name: "UnitRedacted", Type: types.TestTypeUnit, Redact: []string{"build.time"}, Ignore: []string{"id"}, Expected: map[string]any{"build": map[string]any{"time": "[redacted]"}}, Actual: map[string]any{"id": "abc", "build": map[string]any{"time": 123.0}}},
+			runnerConfig:      Config{},
+			setupMockServices: func(t *testing.T, mNix *mockNixService, mSnap *mockSnapshotService, s types.TestSpec, c Config) {},
+			wantStatus:        types.StatusSuccess,
+		},
+		{
+			name:              "Unit test success (normalized store paths)",
+			spec:              types.TestSpec{Name: "UnitStorePaths", Type: types.TestTypeUnit, StorePaths: types.StorePathsNormalize, Expected: "/nix/store/00000000000000000000000000000000-hello", Actual: "/nix/store/11111111111111111111111111111111-hello"},
+			runnerConfig:      Config{},
+			setupMockServices: func(t *testing.T, mNix *mockNixService, mSnap *mockSnapshotService, s types.TestSpec, c Config) {},
+			wantStatus:        types.StatusSuccess,
+		},
+		{
+			name:               "Unit test error (invalid storePaths mode)",
+			spec:               types.TestSpec{Name: "UnitStorePathsInvalid", Type: types.TestTypeUnit, StorePaths: "strip", Expected: 1.0, Actual: 1.0},
+			runnerConfig:       Config{},
+			setupMockServices:  func(t *testing.T, mNix *mockNixService, mSnap *mockSnapshotService, s types.TestSpec, c Config) {},
+			wantStatus:         types.StatusError,
+			wantErrMsgContains: "invalid storePaths mode",
+		},
+		{
+			name:              "Unit test success (comparison modes)",
+			spec:              types.TestSpec{Name: "UnitComparison", Type: types.TestTypeUnit, Comparison: []types.Comparison{types.CompareIgnoreCase, types.CompareUnorderedLists}, Expected: []any{"B", "a"}, Actual: []any{"A", "b"}},
+			runnerConfig:      Config{},
+			setupMockServices: func(t *testing.T, mNix *mockNixService, mSnap *mockSnapshotService, s types.TestSpec, c Config) {},
+			wantStatus:        types.StatusSuccess,
+		},
+		{
+			name:               "Unit test error (invalid comparison)",
+			spec:               types.TestSpec{Name: "UnitComparisonInvalid", Type: types.TestTypeUnit, Comparison: []types.Comparison{"fuzzy"}, Expected: 1.0, Actual: 1.0},
+			runnerConfig:       Config{},
+			setupMockServices:  func(t *testing.T, mNix *mockNixService, mSnap *mockSnapshotService, s types.TestSpec, c Config) {},
+			wantStatus:         types.StatusError,
+			wantErrMsgContains: "invalid comparison",
+		},
+		{
+			name:              "Unit test success (tolerance)",
+			spec:              types.TestSpec{Name: "UnitTolerance", Type: types.TestTypeUnit, Tolerance: types.Tolerance{Relative: 0.01}, Expected: json.Number("0.33"), Actual: 0.3333},
+			runnerConfig:      Config{},
+			setupMockServices: func(t *testing.T, mNix *mockNixService, mSnap *mockSnapshotService, s types.TestSpec, c Config) {},
+			wantStatus:        types.StatusSuccess,
+		},
+		{
+			name:              "Unit test failure (exact numbers)",
+			spec:              types.TestSpec{Name: "UnitExactNumbers", Type: types.TestTypeUnit, Numbers: types.NumbersExact, Expected: json.Number("9007199254740993"), Actual: json.Number("9007199254740992")},
+			runnerConfig:      Config{},
+			setupMockServices: func(t *testing.T, mNix *mockNixService, mSnap *mockSnapshotService, s types.TestSpec, c Config) {},
+			wantStatus:        types.StatusFailure,
+		},
+		{
+			name:              "Unit test success (float numbers round large integers)",
+			spec:              types.TestSpec{Name: "UnitFloatNumbers", Type: types.TestTypeUnit, Expected: json.Number("9007199254740993"), Actual: json.Number("9007199254740992")},
+			runnerConfig:      Config{},
+			setupMockServices: func(t *testing.T, mNix *mockNixService, mSnap *mockSnapshotService, s types.TestSpec, c Config) {},
+			wantStatus:        types.StatusSuccess,
+		},
+		{
+			name:               "Unit test error (negative tolerance)",
+			spec:               types.TestSpec{Name: "UnitToleranceInvalid", Type: types.TestTypeUnit, Tolerance: types.Tolerance{Absolute: -1}, Expected: 1.0, Actual: 1.0},
+			runnerConfig:       Config{},
+			setupMockServices:  func(t *testing.T, mNix *mockNixService, mSnap *mockSnapshotService, s types.TestSpec, c Config) {},
+			wantStatus:         types.StatusError,
+			wantErrMsgContains: "tolerance must not be negative",
+		},
+		{
+			name:               "Unit test error (invalid redact path)",
+			spec:               types.TestSpec{Name: "UnitRedactInvalid", Type: types.TestTypeUnit, Redact: []string{"$"}, Expected: 1.0, Actual: 1.0},
+			runnerConfig:       Config{},
+			setupMockServices:  func(t *testing.T, mNix *mockNixService, mSnap *mockSnapshotService, s types.TestSpec, c Config) {},
+			wantStatus:         types.StatusError,
+			wantErrMsgContains: "invalid path",
+		},
+		{
+			name:               "Unit test error (invalid matcher)",
+			spec:               types.TestSpec{Name: "UnitMatcherInvalid", Type: types.TestTypeUnit, Expected: map[string]any{"$regex": "("}, Actual: "a"},
+			runnerConfig:       Config{},
+			setupMockServices:  func(t *testing.T, mNix *mockNixService, mSnap *mockSnapshotService, s types.TestSpec, c Config) {},
+			wantStatus:         types.StatusError,
+			wantErrMsgContains: "invalid matcher at $",
+		},
 		{
 			name:         "Unit test success with ActualDrv",
 			spec:         types.TestSpec{Name: "UnitActualDrvSuccess", Type: types.TestTypeUnit, Expected: map[string]any{"key": "val"}, ActualDrv: "drv.actual"},
 			runnerConfig: Config{},
 			setupMockServices: func(t *testing.T, mNix *mockNixService, mSnap *mockSnapshotService, s types.TestSpec, c Config) {
-				mNix.BuildAndParseJSONFunc = func(derivation string) (any, error) {
+				mNix.BuildAndParseFunc = func(derivation string, format types.Format) (any, error) {
 					if derivation == "drv.actual" {
 						return map[string]any{"key": "val"}, nil
 					}
@@ -186,12 +331,38 @@ func TestRunner_executeTest(t *testing.T) {
 			},
 			wantStatus: types.StatusSuccess,
 		},
+		{
+			name:         "Unit test success with YAML ActualDrv",
+			spec:         types.TestSpec{Name: "UnitActualDrvYAML", Type: types.TestTypeUnit, Expected: map[string]any{"replicas": 3.0}, ActualDrv: "drv.actual.yaml", ActualFormat: types.FormatYAML},
+			runnerConfig: Config{},
+			setupMockServices: func(t *testing.T, mNix *mockNixService, mSnap *mockSnapshotService, s types.TestSpec, c Config) {
+				mNix.BuildAndParseFunc = func(derivation string, format types.Format) (any, error) {
+					if format != types.FormatYAML {
+						t.Errorf("BuildAndParse() format = %q, want %q", format, types.FormatYAML)
+					}
+					return map[string]any{"replicas": json.Number("3")}, nil
+				}
+			},
+			wantStatus: types.StatusSuccess,
+		},
+		{
+			name:         "Unit test error (ActualDrv parse fail)",
+			spec:         types.TestSpec{Name: "UnitActualDrvParseError", Type: types.TestTypeUnit, Expected: "any", ActualDrv: "drv.actual.toml", ActualFormat: types.FormatTOML},
+			runnerConfig: Config{},
+			setupMockServices: func(t *testing.T, mNix *mockNixService, mSnap *mockSnapshotService, s types.TestSpec, c Config) {
+				mNix.BuildAndParseFunc = func(derivation string, format types.Format) (any, error) {
+					return nil, &apperrors.ParseError{Format: string(format), Source: "/nix/store/abc-config.toml", Err: errors.New("expected value")}
+				}
+			},
+			wantStatus:         types.StatusError,
+			wantErrMsgContains: "failed to build/parse actualDrv drv.actual.toml: failed to parse toml from /nix/store/abc-config.toml",
+		},
 		{
 			name:         "Unit test error (ActualDrv build fail)",
 			spec:         types.TestSpec{Name: "UnitActualDrvError", Type: types.TestTypeUnit, Expected: "any", ActualDrv: "drv.actual.fail"},
 			runnerConfig: Config{},
 			setupMockServices: func(t *testing.T, mNix *mockNixService, mSnap *mockSnapshotService, s types.TestSpec, c Config) {
-				mNix.BuildAndParseJSONFunc = func(derivation string) (any, error) {
+				mNix.BuildAndParseFunc = func(derivation string, format types.Format) (any, error) {
 					return nil, &apperrors.NixBuildError{Derivation: "drv.actual.fail", Err: errors.New("build failed")}
 				}
 			},
@@ -220,6 +391,18 @@ func TestRunner_executeTest(t *testing.T) {
 			},
 			wantStatus: types.StatusSuccess,
 		},
+		{
+			name:         "Snapshot test failure (matcher keys in snapshot are data)",
+			spec:         types.TestSpec{Name: "SnapMatcherData", Type: types.TestTypeSnapshot, Actual: map[string]any{"query": "x"}},
+			runnerConfig: Config{SnapshotDir: tempDir},
+			setupMockServices: func(t *testing.T, mNix *mockNixService, mSnap *mockSnapshotService, s types.TestSpec, c Config) {
+				mSnap.StatFunc = func(name string) (os.FileInfo, error) { return mockFileInfo{}, nil }
+				mSnap.LoadFileFunc = func(filePath string) (any, error) {
+					return map[string]any{"query": map[string]any{"$regex": "."}}, nil
+				}
+			},
+			wantStatus: types.StatusFailure,
+		},
 		{
 			name:         "Snapshot test update (snapshot created, no prior)",
 			spec:         types.TestSpec{Name: "SnapUpdateNew", Type: types.TestTypeSnapshot, Actual: map[string]any{"data": "new"}},
@@ -247,14 +430,351 @@ func TestRunner_executeTest(t *testing.T) {
 			},
 			wantStatus: types.StatusSuccess,
 		},
+		{
+			name:         "Snapshot test update (redacted and ignored paths)",
+			spec:         types.TestSpec{Name: "SnapUpdateRedacted", Type: types.TestTypeSnapshot, Actual: map[string]any{"data": "new", "time": 123.0, "id": "abc"}, Redact: []string{"time"}, Ignore: []string{"/id"}},
+			runnerConfig: Config{SnapshotDir: tempDir, UpdateSnapshots: true},
+			setupMockServices: func(t *testing.T, mNix *mockNixService, mSnap *mockSnapshotService, s types.TestSpec, c Config) {
+				var written any
+				mSnap.CreateFileFunc = func(filePath string, data any) error {
+					want := map[string]any{"data": "new", "time": "[redacted]"}
+					if !reflect.DeepEqual(data, want) {
+						t.Errorf("CreateFile() data = %#v, want %#v", data, want)
+					}
+					written = data
+					return nil
+				}
+				mSnap.StatFunc = func(name string) (os.FileInfo, error) { return mockFileInfo{}, nil }
+				mSnap.LoadFileFunc = func(filePath string) (any, error) { return written, nil }
+			},
+			wantStatus: types.StatusSuccess,
+		},
+		// --- Tree Tests ---
+		{
+			name:         "Tree test success",
+			spec:         types.TestSpec{Name: "TreeSuccess", Type: types.TestTypeTree, Derivation: "/nix/store/abc-site.drv"},
+			runnerConfig: Config{SnapshotDir: tempDir},
+			setupMockServices: func(t *testing.T, mNix *mockNixService, mSnap *mockSnapshotService, s types.TestSpec, c Config) {
+				out := t.TempDir()
+				if err := os.Chmod(out, 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(filepath.Join(out, "index.html"), []byte("<h1>hi</h1>\n"), 0644); err != nil {
+					t.Fatal(err)
+				}
+				mNix.BuildDerivationFunc = func(d string) (string, error) { return out, nil }
+				mSnap.StatFunc = func(name string) (os.FileInfo, error) { return mockFileInfo{}, nil }
+				mSnap.LoadFileFunc = func(filePath string) (any, error) {
+					return map[string]any{
+						".":          map[string]any{"type": "directory", "mode": "0755"},
+						"index.html": map[string]any{"type": "file", "mode": "0644", "content": "<h1>hi</h1>\n"},
+					}, nil
+				}
+			},
+			wantStatus: types.StatusSuccess,
+		},
+		{
+			name:         "Tree test failure (file content changed)",
+			spec:         types.TestSpec{Name: "TreeChanged", Type: types.TestTypeTree, Derivation: "/nix/store/abc-site.drv", Ignore: []string{"**.mode"}},
+			runnerConfig: Config{SnapshotDir: tempDir},
+			setupMockServices: func(t *testing.T, mNix *mockNixService, mSnap *mockSnapshotService, s types.TestSpec, c Config) {
+				out := t.TempDir()
+				if err := os.WriteFile(filepath.Join(out, "index.html"), []byte("<h1>hello</h1>\n"), 0644); err != nil {
+					t.Fatal(err)
+				}
+				mNix.BuildDerivationFunc = func(d string) (string, error) { return out, nil }
+				mSnap.StatFunc = func(name string) (os.FileInfo, error) { return mockFileInfo{}, nil }
+				mSnap.LoadFileFunc = func(filePath string) (any, error) {
+					return map[string]any{
+						".":          map[string]any{"type": "directory"},
+						"index.html": map[string]any{"type": "file", "content": "<h1>hi</h1>\n"},
+					}, nil
+				}
+			},
+			wantStatus: types.StatusFailure,
+		},
+		{
+			name:         "Tree test error (build failed)",
+			spec:         types.TestSpec{Name: "TreeBuildError", Type: types.TestTypeTree, Derivation: "/nix/store/abc-site.drv"},
+			runnerConfig: Config{SnapshotDir: tempDir},
+			setupMockServices: func(t *testing.T, mNix *mockNixService, mSnap *mockSnapshotService, s types.TestSpec, c Config) {
+				mNix.BuildDerivationFunc = func(d string) (string, error) {
+					return "", &apperrors.NixBuildError{Derivation: d, Err: errors.New("build failed")}
+				}
+			},
+			wantStatus:         types.StatusError,
+			wantErrMsgContains: "[system] failed to build/read derivation /nix/store/abc-site.drv",
+		},
+		// --- Eval Tests ---
+		{
+			name:         "Eval test success",
+			spec:         types.TestSpec{Name: "EvalSuccess", Type: types.TestTypeEval, Flake: ".", Attr: "lib.value", Expected: map[string]any{"a": 1.0}},
+			runnerConfig: Config{},
+			setupMockServices: func(t *testing.T, mNix *mockNixService, mSnap *mockSnapshotService, s types.TestSpec, c Config) {
+				mNix.EvalFunc = func(target nix.EvalTarget) (any, error) {
+					if target != (nix.EvalTarget{Flake: ".", Attr: "lib.value"}) {
+						t.Errorf("Eval() target = %#v", target)
+					}
+					return map[string]any{"a": json.Number("1")}, nil
+				}
+			},
+			wantStatus: types.StatusSuccess,
+		},
+		{
+			name:         "Eval test failure (mismatch)",
+			spec:         types.TestSpec{Name: "EvalMismatch", Type: types.TestTypeEval, File: "/src/tests.nix", Attr: "value", Expected: 2.0},
+			runnerConfig: Config{},
+			setupMockServices: func(t *testing.T, mNix *mockNixService, mSnap *mockSnapshotService, s types.TestSpec, c Config) {
+				mNix.EvalFunc = func(target nix.EvalTarget) (any, error) { return json.Number("1"), nil }
+			},
+			wantStatus: types.StatusFailure,
+		},
+		{
+			name:         "Eval test failure (evaluation error)",
+			spec:         types.TestSpec{Name: "EvalThrows", Type: types.TestTypeEval, File: "/src/tests.nix", Attr: "value", Expected: 1.0},
+			runnerConfig: Config{},
+			setupMockServices: func(t *testing.T, mNix *mockNixService, mSnap *mockSnapshotService, s types.TestSpec, c Config) {
+				mNix.EvalFunc = func(target nix.EvalTarget) (any, error) {
+					return nil, &apperrors.NixEvalError{Target: target.String(), Stderr: "error:\n  … while evaluating\n  error: boom\n", Err: errors.New("exit status 1")}
+				}
+			},
+			wantStatus:         types.StatusFailure,
+			wantErrMsgContains: "evaluation of /src/tests.nix value failed:\nerror:\n  … while evaluating\n  error: boom",
+		},
+		{
+			name:         "Eval test error (invalid target)",
+			spec:         types.TestSpec{Name: "EvalInvalid", Type: types.TestTypeEval, Attr: "value", Expected: 1.0},
+			runnerConfig: Config{},
+			setupMockServices: func(t *testing.T, mNix *mockNixService, mSnap *mockSnapshotService, s types.TestSpec, c Config) {
+				mNix.EvalFunc = func(target nix.EvalTarget) (any, error) { return nil, errors.New("a target is required") }
+			},
+			wantStatus:         types.StatusError,
+			wantErrMsgContains: "[system] failed to evaluate",
+		},
+		{
+			name:         "Eval test error (nix killed)",
+			spec:         types.TestSpec{Name: "EvalKilled", Type: types.TestTypeEval, File: "/src/tests.nix", Attr: "value", Expected: 1.0},
+			runnerConfig: Config{},
+			setupMockServices: func(t *testing.T, mNix *mockNixService, mSnap *mockSnapshotService, s types.TestSpec, c Config) {
+				mNix.EvalFunc = func(target nix.EvalTarget) (any, error) {
+					return nil, fmt.Errorf("failed to run nix eval for %s: %w", target, errors.New("signal: killed"))
+				}
+			},
+			wantStatus:         types.StatusError,
+			wantErrMsgContains: "[system] failed to evaluate /src/tests.nix value: failed to run nix eval for /src/tests.nix value: signal: killed",
+		},
+		// --- Throws Tests ---
+		{
+			name:         "Throws test success",
+			spec:         types.TestSpec{Name: "ThrowsSuccess", Type: types.TestTypeThrows, File: "/src/tests.nix", Attr: "bad", Contains: "invalid version", Pattern: "^invalid .*: 1\\.x$"},
+			runnerConfig: Config{},
+			setupMockServices: func(t *testing.T, mNix *mockNixService, mSnap *mockSnapshotService, s types.TestSpec, c Config) {
+				mNix.EvalFunc = func(target nix.EvalTarget) (any, error) {
+					return nil, &apperrors.NixEvalError{Target: target.String(), Stderr: "error:\n       … while calling the 'throw' builtin\n\n       error: invalid version: 1.x\n", Err: errors.New("exit status 1")}
+				}
+			},
+			wantStatus: types.StatusSuccess,
+		},
+		{
+			name:         "Throws test failure (no error)",
+			spec:         types.TestSpec{Name: "ThrowsNoError", Type: types.TestTypeThrows, File: "/src/tests.nix", Attr: "good"},
+			runnerConfig: Config{},
+			setupMockServices: func(t *testing.T, mNix *mockNixService, mSnap *mockSnapshotService, s types.TestSpec, c Config) {
+				mNix.EvalFunc = func(target nix.EvalTarget) (any, error) { return map[string]any{"major": json.Number("1")}, nil }
+			},
+			wantStatus:         types.StatusFailure,
+			wantErrMsgContains: "expected evaluation of /src/tests.nix good to fail, but it returned:\n{\n  \"major\": 1\n}",
+		},
+		{
+			name:         "Throws test failure (message mismatch)",
+			spec:         types.TestSpec{Name: "ThrowsMismatch", Type: types.TestTypeThrows, File: "/src/tests.nix", Attr: "bad", Contains: "missing major"},
+			runnerConfig: Config{},
+			setupMockServices: func(t *testing.T, mNix *mockNixService, mSnap *mockSnapshotService, s types.TestSpec, c Config) {
+				mNix.EvalFunc = func(target nix.EvalTarget) (any, error) {
+					return nil, &apperrors.NixEvalError{Target: target.String(), Stderr: "error:\n       … while calling the 'throw' builtin\n\n       error: invalid version: 1.x\n", Err: errors.New("exit status 1")}
+				}
+			},
+			wantStatus:         types.StatusFailure,
+			wantErrMsgContains: "failed with an unexpected message, expected one containing \"missing major\", got:\ninvalid version: 1.x",
+		},
+		{
+			name:         "Throws test failure (pattern mismatch)",
+			spec:         types.TestSpec{Name: "ThrowsPatternMismatch", Type: types.TestTypeThrows, File: "/src/tests.nix", Attr: "bad", Pattern: "^missing"},
+			runnerConfig: Config{},
+			setupMockServices: func(t *testing.T, mNix *mockNixService, mSnap *mockSnapshotService, s types.TestSpec, c Config) {
+				mNix.EvalFunc = func(target nix.EvalTarget) (any, error) {
+					return nil, &apperrors.NixEvalError{Target: target.String(), Stderr: "error:\n       … while calling the 'throw' builtin\n\n       error: invalid version: 1.x\n", Err: errors.New("exit status 1")}
+				}
+			},
+			wantStatus:         types.StatusFailure,
+			wantErrMsgContains: "matching /^missing/",
+		},
+		{
+			name:         "Throws test error (invalid pattern)",
+			spec:         types.TestSpec{Name: "ThrowsInvalidPattern", Type: types.TestTypeThrows, File: "/src/tests.nix", Attr: "bad", Pattern: "(["},
+			runnerConfig: Config{},
+			setupMockServices: func(t *testing.T, mNix *mockNixService, mSnap *mockSnapshotService, s types.TestSpec, c Config) {
+				mNix.EvalFunc = func(target nix.EvalTarget) (any, error) {
+					return nil, &apperrors.NixEvalError{Target: target.String(), Stderr: "error:\n       … while calling the 'throw' builtin\n\n       error: invalid version: 1.x\n", Err: errors.New("exit status 1")}
+				}
+			},
+			wantStatus:         types.StatusError,
+			wantErrMsgContains: "[system] invalid pattern",
+		},
+		{
+			name:         "Throws test error (typo in attribute)",
+			spec:         types.TestSpec{Name: "ThrowsTypo", Type: types.TestTypeThrows, File: "/src/tests.nix", Attr: "lib.bda"},
+			runnerConfig: Config{},
+			setupMockServices: func(t *testing.T, mNix *mockNixService, mSnap *mockSnapshotService, s types.TestSpec, c Config) {
+				mNix.EvalFunc = func(target nix.EvalTarget) (any, error) {
+					return nil, &apperrors.NixEvalError{Target: target.String(), Stderr: "error: attribute 'bda' in selection path 'lib.bda' not found\n", Err: errors.New("exit status 1")}
+				}
+			},
+			wantStatus:         types.StatusError,
+			wantErrMsgContains: "[system] failed to evaluate /src/tests.nix lib.bda, it can't be resolved: attribute 'bda' in selection path 'lib.bda' not found",
+		},
+		{
+			name:         "Throws test success (throw mentioning a download)",
+			spec:         types.TestSpec{Name: "ThrowsDownload", Type: types.TestTypeThrows, File: "/src/tests.nix", Attr: "fetchData", Contains: "unable to download"},
+			runnerConfig: Config{},
+			setupMockServices: func(t *testing.T, mNix *mockNixService, mSnap *mockSnapshotService, s types.TestSpec, c Config) {
+				mNix.EvalFunc = func(target nix.EvalTarget) (any, error) {
+					return nil, &apperrors.NixEvalError{Target: target.String(), Stderr: "error:\n       … while calling the 'throw' builtin\n\n       error: unable to download 'https://example.com/data.json' in pure mode\n", Err: errors.New("exit status 1")}
+				}
+			},
+			wantStatus: types.StatusSuccess,
+		},
+		{
+			name:         "Throws test error (non-zero exit with empty stderr)",
+			spec:         types.TestSpec{Name: "ThrowsEmptyStderr", Type: types.TestTypeThrows, File: "/src/tests.nix", Attr: "bad"},
+			runnerConfig: Config{},
+			setupMockServices: func(t *testing.T, mNix *mockNixService, mSnap *mockSnapshotService, s types.TestSpec, c Config) {
+				mNix.EvalFunc = func(target nix.EvalTarget) (any, error) {
+					return nil, &apperrors.NixEvalError{Target: target.String(), Stderr: "", Err: errors.New("exit status 1")}
+				}
+			},
+			wantStatus:         types.StatusError,
+			wantErrMsgContains: "[system] evaluation of /src/tests.nix bad failed without an error message: exit status 1",
+		},
+		{
+			name:         "Throws test error (nix not runnable)",
+			spec:         types.TestSpec{Name: "ThrowsNoNix", Type: types.TestTypeThrows, File: "/src/tests.nix", Attr: "bad"},
+			runnerConfig: Config{},
+			setupMockServices: func(t *testing.T, mNix *mockNixService, mSnap *mockSnapshotService, s types.TestSpec, c Config) {
+				mNix.EvalFunc = func(target nix.EvalTarget) (any, error) {
+					return nil, fmt.Errorf("failed to run nix eval for %s: %w", target, exec.ErrNotFound)
+				}
+			},
+			wantStatus:         types.StatusError,
+			wantErrMsgContains: "[system] failed to evaluate /src/tests.nix bad: failed to run nix eval",
+		},
+		{
+			name:         "Throws test error (nix failure)",
+			spec:         types.TestSpec{Name: "ThrowsSystemError", Type: types.TestTypeThrows, Flake: ".", Attr: "bad"},
+			runnerConfig: Config{},
+			setupMockServices: func(t *testing.T, mNix *mockNixService, mSnap *mockSnapshotService, s types.TestSpec, c Config) {
+				mNix.EvalFunc = func(target nix.EvalTarget) (any, error) {
+					return nil, errors.New("exec: \"nix\": executable file not found")
+				}
+			},
+			wantStatus:         types.StatusError,
+			wantErrMsgContains: "[system] failed to evaluate .#bad",
+		},
+		// --- Build Tests ---
+		{
+			name:         "Build test success",
+			spec:         types.TestSpec{Name: "BuildSuccess", Type: types.TestTypeBuild, Derivation: "/nix/store/abc-ok.drv"},
+			runnerConfig: Config{},
+			setupMockServices: func(t *testing.T, mNix *mockNixService, mSnap *mockSnapshotService, s types.TestSpec, c Config) {
+				mNix.BuildDerivationFunc = func(d string) (string, error) { return "/nix/store/abc-ok", nil }
+			},
+			wantStatus: types.StatusSuccess,
+		},
+		{
+			name:         "Build test failure",
+			spec:         types.TestSpec{Name: "BuildFailure", Type: types.TestTypeBuild, Derivation: "/nix/store/abc-broken.drv"},
+			runnerConfig: Config{},
+			setupMockServices: func(t *testing.T, mNix *mockNixService, mSnap *mockSnapshotService, s types.TestSpec, c Config) {
+				mNix.BuildDerivationFunc = func(d string) (string, error) {
+					return "", &apperrors.NixBuildError{Derivation: d, Stderr: "error: builder for '/nix/store/abc-broken.drv' failed with exit code 1;\n       last 2 log lines:\n       > checking config\n       > \x1b[31merror: port must be a number\x1b[0m\n", Err: errors.New("exit status 1")}
+				}
+			},
+			wantStatus:         types.StatusFailure,
+			wantErrMsgContains: "build of /nix/store/abc-broken.drv failed:\nerror: builder for",
+		},
+		{
+			name:         "Build test error (no output path)",
+			spec:         types.TestSpec{Name: "BuildNoOutput", Type: types.TestTypeBuild, Derivation: "/nix/store/abc-empty.drv"},
+			runnerConfig: Config{},
+			setupMockServices: func(t *testing.T, mNix *mockNixService, mSnap *mockSnapshotService, s types.TestSpec, c Config) {
+				mNix.BuildDerivationFunc = func(d string) (string, error) {
+					return "", &apperrors.NixNoOutputPathError{Derivation: d}
+				}
+			},
+			wantStatus:         types.StatusError,
+			wantErrMsgContains: "[system] failed to build derivation /nix/store/abc-empty.drv",
+		},
+		{
+			name:         "BuildFails test success",
+			spec:         types.TestSpec{Name: "BuildFailsSuccess", Type: types.TestTypeBuildFails, Derivation: "/nix/store/abc-broken.drv", Contains: "PORT is unset", Pattern: "port must be a number$"},
+			runnerConfig: Config{},
+			setupMockServices: func(t *testing.T, mNix *mockNixService, mSnap *mockSnapshotService, s types.TestSpec, c Config) {
+				mNix.BuildDerivationFunc = func(d string) (string, error) {
+					return "", &apperrors.NixBuildError{Derivation: d, Stderr: "error: builder for '/nix/store/abc-broken.drv' failed with exit code 1;\n       last 2 log lines:\n       > checking config\n       > \x1b[31merror: port must be a number\x1b[0m\n", Err: errors.New("exit status 1")}
+				}
+				mNix.BuildLogFunc = func(d string) (string, error) {
+					if d != "/nix/store/abc-broken.drv" {
+						t.Errorf("BuildLog() called with %s", d)
+					}
+					return "unpacking sources\nwarning: PORT is unset\nchecking config\n\x1b[31merror: port must be a number\x1b[0m\n", nil
+				}
+			},
+			wantStatus: types.StatusSuccess,
+		},
+		{
+			name:         "BuildFails test error (no builder ran)",
+			spec:         types.TestSpec{Name: "BuildFailsMissing", Type: types.TestTypeBuildFails, Derivation: "/nix/store/abc-missing.drv"},
+			runnerConfig: Config{},
+			setupMockServices: func(t *testing.T, mNix *mockNixService, mSnap *mockSnapshotService, s types.TestSpec, c Config) {
+				mNix.BuildDerivationFunc = func(d string) (string, error) {
+					return "", &apperrors.NixBuildError{Derivation: d, Stderr: "error: path '/nix/store/abc-missing.drv' is not valid\n", Err: errors.New("exit status 1")}
+				}
+			},
+			wantStatus:         types.StatusError,
+			wantErrMsgContains: "[system] failed to build derivation /nix/store/abc-missing.drv, no builder ran:\nerror: path '/nix/store/abc-missing.drv' is not valid",
+		},
+		{
+			name:         "BuildFails test failure (build succeeded)",
+			spec:         types.TestSpec{Name: "BuildFailsBuilt", Type: types.TestTypeBuildFails, Derivation: "/nix/store/abc-ok.drv"},
+			runnerConfig: Config{},
+			setupMockServices: func(t *testing.T, mNix *mockNixService, mSnap *mockSnapshotService, s types.TestSpec, c Config) {
+				mNix.BuildDerivationFunc = func(d string) (string, error) { return "/nix/store/abc-ok", nil }
+			},
+			wantStatus:         types.StatusFailure,
+			wantErrMsgContains: "expected build of /nix/store/abc-ok.drv to fail, but it succeeded with /nix/store/abc-ok",
+		},
+		{
+			name:         "BuildFails test failure (log mismatch)",
+			spec:         types.TestSpec{Name: "BuildFailsMismatch", Type: types.TestTypeBuildFails, Derivation: "/nix/store/abc-broken.drv", Contains: "missing port"},
+			runnerConfig: Config{},
+			setupMockServices: func(t *testing.T, mNix *mockNixService, mSnap *mockSnapshotService, s types.TestSpec, c Config) {
+				mNix.BuildDerivationFunc = func(d string) (string, error) {
+					return "", &apperrors.NixBuildError{Derivation: d, Stderr: "error: builder for '/nix/store/abc-broken.drv' failed with exit code 1;\n       last 2 log lines:\n       > checking config\n       > \x1b[31merror: port must be a number\x1b[0m\n", Err: errors.New("exit status 1")}
+				}
+				// without the full log the output of nix build is matched
+				mNix.BuildLogFunc = func(d string) (string, error) { return "", errors.New("build log is not available") }
+			},
+			wantStatus:         types.StatusFailure,
+			wantErrMsgContains: "failed with an unexpected log, expected one containing \"missing port\", got:\nerror: builder for",
+		},
 		// --- Script Tests ---
 		{
 			name:         "Script test success (exit 0)",
 			spec:         types.TestSpec{Name: "ScriptSuccess", Type: types.TestTypeScript, Script: "script.sh"},
 			runnerConfig: Config{},
 			setupMockServices: func(t *testing.T, mNix *mockNixService, mSnap *mockSnapshotService, s types.TestSpec, c Config) {
-				mNix.BuildAndRunScriptFunc = func(derivation string, impureEnv bool) (int, string, string, error) {
-					return 0, "stdout", "stderr", nil
+				mNix.BuildAndRunScriptFunc = func(derivation string, opts nix.ScriptOptions) (nix.ScriptResult, error) {
+					return nix.ScriptResult{ExitCode: 0, Stdout: "stdout", Stderr: "stderr"}, nil
 				}
 			},
 			wantStatus: types.StatusSuccess,
@@ -264,13 +784,165 @@ func TestRunner_executeTest(t *testing.T) {
 			spec:         types.TestSpec{Name: "ScriptFail", Type: types.TestTypeScript, Script: "script.sh"},
 			runnerConfig: Config{},
 			setupMockServices: func(t *testing.T, mNix *mockNixService, mSnap *mockSnapshotService, s types.TestSpec, c Config) {
-				mNix.BuildAndRunScriptFunc = func(derivation string, impureEnv bool) (int, string, string, error) {
-					return 1, "out on fail", "err on fail", nil
+				mNix.BuildAndRunScriptFunc = func(derivation string, opts nix.ScriptOptions) (nix.ScriptResult, error) {
+					return nix.ScriptResult{ExitCode: 1, Stdout: "out on fail", Stderr: "err on fail"}, nil
 				}
 			},
 			wantStatus:         types.StatusFailure,
 			wantErrMsgContains: "[exit code 1]\n[stdout]\nout on fail\n[stderr]\nerr on fail",
 		},
+		{
+			name: "Script test passes interpreter options",
+			spec: types.TestSpec{
+				Name: "ScriptInterpreter", Type: types.TestTypeScript, Script: "script.py",
+				Interpreter: "/bin/python3", Args: []string{"--flag"}, Stdin: "input",
+			},
+			runnerConfig: Config{ImpureEnv: true},
+			setupMockServices: func(t *testing.T, mNix *mockNixService, mSnap *mockSnapshotService, s types.TestSpec, c Config) {
+				mNix.BuildAndRunScriptFunc = func(derivation string, opts nix.ScriptOptions) (nix.ScriptResult, error) {
+					want := nix.ScriptOptions{ImpureEnv: true, Interpreter: "/bin/python3", Args: []string{"--flag"}, Stdin: "input"}
+					if !reflect.DeepEqual(opts, want) {
+						t.Errorf("BuildAndRunScript() opts = %+v, want %+v", opts, want)
+					}
+					return nix.ScriptResult{ExitCode: 0}, nil
+				}
+			},
+			wantStatus: types.StatusSuccess,
+		},
+		{
+			name:         "Script test failure (limit exceeded)",
+			spec:         types.TestSpec{Name: "ScriptLimit", Type: types.TestTypeScript, Script: "script.sh", Limits: types.ResourceLimits{CPUTime: 1}},
+			runnerConfig: Config{},
+			setupMockServices: func(t *testing.T, mNix *mockNixService, mSnap *mockSnapshotService, s types.TestSpec, c Config) {
+				mNix.BuildAndRunScriptFunc = func(derivation string, opts nix.ScriptOptions) (nix.ScriptResult, error) {
+					if opts.Limits.CPUTime != 1 {
+						t.Errorf("BuildAndRunScript() limits = %+v, want CPUTime 1", opts.Limits)
+					}
+					return nix.ScriptResult{ExitCode: 152, LimitExceeded: "CPU time limit of 1s exceeded", CPUTime: time.Second}, nil
+				}
+			},
+			wantStatus:         types.StatusFailure,
+			wantErrMsgContains: "[limit exceeded] CPU time limit of 1s exceeded",
+		},
+		{
+			name:         "Script test failure (killed by signal)",
+			spec:         types.TestSpec{Name: "ScriptSignal", Type: types.TestTypeScript, Script: "script.sh", Limits: types.ResourceLimits{Memory: 1 << 30}},
+			runnerConfig: Config{},
+			setupMockServices: func(t *testing.T, mNix *mockNixService, mSnap *mockSnapshotService, s types.TestSpec, c Config) {
+				mNix.BuildAndRunScriptFunc = func(derivation string, opts nix.ScriptOptions) (nix.ScriptResult, error) {
+					return nix.ScriptResult{ExitCode: -1, Signal: "segmentation fault"}, nil
+				}
+			},
+			wantStatus:         types.StatusFailure,
+			wantErrMsgContains: "[killed by signal: segmentation fault]\n[stdout]",
+		},
+		{
+			name:         "Script test failure (truncated output)",
+			spec:         types.TestSpec{Suite: "Suite", Name: "ScriptTruncated", Type: types.TestTypeScript, Script: "script.sh"},
+			runnerConfig: Config{OutputLimit: 10, OutputSpillDir: "/tmp/spill"},
+			setupMockServices: func(t *testing.T, mNix *mockNixService, mSnap *mockSnapshotService, s types.TestSpec, c Config) {
+				mNix.BuildAndRunScriptFunc = func(derivation string, opts nix.ScriptOptions) (nix.ScriptResult, error) {
+					if opts.OutputLimit != 10 || opts.SpillDir != filepath.Join("/tmp/spill", "Suite", "ScriptTruncated") {
+						t.Errorf("BuildAndRunScript() opts = %+v", opts)
+					}
+					return nix.ScriptResult{ExitCode: 1, Stdout: "trunc", StdoutFile: "/tmp/spill/Suite/ScriptTruncated/stdout.log"}, nil
+				}
+			},
+			wantStatus:         types.StatusFailure,
+			wantErrMsgContains: "[full stdout in /tmp/spill/Suite/ScriptTruncated/stdout.log]",
+		},
+		{
+			name:         "Script test failure (exit 0, failing TAP sub-result)",
+			spec:         types.TestSpec{Name: "ScriptTAPFail", Type: types.TestTypeScript, Script: "script.sh"},
+			runnerConfig: Config{},
+			setupMockServices: func(t *testing.T, mNix *mockNixService, mSnap *mockSnapshotService, s types.TestSpec, c Config) {
+				mNix.BuildAndRunScriptFunc = func(derivation string, opts nix.ScriptOptions) (nix.ScriptResult, error) {
+					return nix.ScriptResult{ExitCode: 0, Stdout: "1..2\nok 1 - a\nnot ok 2 - b\n", SubResults: []types.SubResult{
+						{Name: "a", Status: types.StatusSuccess},
+						{Name: "b", Status: types.StatusFailure},
+					}}, nil
+				}
+			},
+			wantStatus:         types.StatusFailure,
+			wantErrMsgContains: "[1 of 2 sub-results failed]",
+		},
+		{
+			name:         "Script test success (passing JSON lines sub-results)",
+			spec:         types.TestSpec{Name: "ScriptJSONLines", Type: types.TestTypeScript, Script: "script.sh"},
+			runnerConfig: Config{},
+			setupMockServices: func(t *testing.T, mNix *mockNixService, mSnap *mockSnapshotService, s types.TestSpec, c Config) {
+				mNix.BuildAndRunScriptFunc = func(derivation string, opts nix.ScriptOptions) (nix.ScriptResult, error) {
+					return nix.ScriptResult{ExitCode: 0, Results: `{"name": "a", "status": "pass"}`}, nil
+				}
+			},
+			wantStatus: types.StatusSuccess,
+		},
+		{
+			name:         "Script test error (invalid JSON lines sub-results)",
+			spec:         types.TestSpec{Name: "ScriptJSONLinesInvalid", Type: types.TestTypeScript, Script: "script.sh"},
+			runnerConfig: Config{},
+			setupMockServices: func(t *testing.T, mNix *mockNixService, mSnap *mockSnapshotService, s types.TestSpec, c Config) {
+				mNix.BuildAndRunScriptFunc = func(derivation string, opts nix.ScriptOptions) (nix.ScriptResult, error) {
+					return nix.ScriptResult{ExitCode: 0, Results: `{"name": "a"`}, nil
+				}
+			},
+			wantStatus:         types.StatusError,
+			wantErrMsgContains: "failed to parse $NIXTEST_RESULTS",
+		},
+		// --- VM Tests ---
+		{
+			name:         "VM test success",
+			spec:         types.TestSpec{Name: "VMSuccess", Type: types.TestTypeVM, Driver: "driver.drv"},
+			runnerConfig: Config{},
+			setupMockServices: func(t *testing.T, mNix *mockNixService, mSnap *mockSnapshotService, s types.TestSpec, c Config) {
+				mNix.BuildAndRunVMFunc = func(driver string, opts nix.ScriptOptions) (nix.ScriptResult, error) {
+					if driver != s.Driver {
+						t.Errorf("BuildAndRunVM() driver = %q, want %q", driver, s.Driver)
+					}
+					return nix.ScriptResult{ExitCode: 0, SubResults: []types.SubResult{{Name: "boots", Status: types.StatusSuccess}}}, nil
+				}
+			},
+			wantStatus: types.StatusSuccess,
+		},
+		{
+			name:         "VM test failure (failed subtest)",
+			spec:         types.TestSpec{Name: "VMSubtestFail", Type: types.TestTypeVM, Driver: "driver.drv"},
+			runnerConfig: Config{},
+			setupMockServices: func(t *testing.T, mNix *mockNixService, mSnap *mockSnapshotService, s types.TestSpec, c Config) {
+				mNix.BuildAndRunVMFunc = func(driver string, opts nix.ScriptOptions) (nix.ScriptResult, error) {
+					return nix.ScriptResult{ExitCode: 1, SubResults: []types.SubResult{{Name: "boots", Status: types.StatusFailure, Message: "timeout"}}}, nil
+				}
+			},
+			wantStatus:         types.StatusFailure,
+			wantErrMsgContains: "[exit code 1]",
+		},
+		{
+			name:         "VM test failure (exit 0, failed subtest)",
+			spec:         types.TestSpec{Name: "VMSubtestFailExit0", Type: types.TestTypeVM, Driver: "driver.drv"},
+			runnerConfig: Config{},
+			setupMockServices: func(t *testing.T, mNix *mockNixService, mSnap *mockSnapshotService, s types.TestSpec, c Config) {
+				mNix.BuildAndRunVMFunc = func(driver string, opts nix.ScriptOptions) (nix.ScriptResult, error) {
+					return nix.ScriptResult{ExitCode: 0, SubResults: []types.SubResult{
+						{Name: "boots", Status: types.StatusSuccess},
+						{Name: "serves", Status: types.StatusFailure, Message: "timeout"},
+					}}, nil
+				}
+			},
+			wantStatus:         types.StatusFailure,
+			wantErrMsgContains: "[1 of 2 sub-results failed]",
+		},
+		{
+			name:         "VM test error (driver build fails)",
+			spec:         types.TestSpec{Name: "VMBuildFail", Type: types.TestTypeVM, Driver: "driver.drv"},
+			runnerConfig: Config{},
+			setupMockServices: func(t *testing.T, mNix *mockNixService, mSnap *mockSnapshotService, s types.TestSpec, c Config) {
+				mNix.BuildAndRunVMFunc = func(driver string, opts nix.ScriptOptions) (nix.ScriptResult, error) {
+					return nix.ScriptResult{}, errors.New("build failed")
+				}
+			},
+			wantStatus:         types.StatusError,
+			wantErrMsgContains: "failed to run vm derivation driver.drv",
+		},
 	}
 
 	for _, tt := range tests {
@@ -308,12 +980,119 @@ func TestRunner_executeTest(t *testing.T) {
 	}
 }
 
+func TestRunner_cleanupWorkDir(t *testing.T) {
+	tests := []struct {
+		name     string
+		keepTmp  string
+		status   types.TestStatus
+		wantKept bool
+	}{
+		{"Never keep, failed", KeepTmpNever, types.StatusFailure, false},
+		{"Keep failed, failed", KeepTmpFailed, types.StatusFailure, true},
+		{"Keep failed, errored", KeepTmpFailed, types.StatusError, true},
+		{"Keep failed, success", KeepTmpFailed, types.StatusSuccess, false},
+		{"Keep always, success", KeepTmpAlways, types.StatusSuccess, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			workDir, err := os.MkdirTemp(t.TempDir(), "nixtest-script-")
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the artifacts directory follows the same rule, even without --artifacts-dir
+			artifactsTmpDir, err := os.MkdirTemp(t.TempDir(), "nixtest-artifacts-")
+			if err != nil {
+				t.Fatal(err)
+			}
+			mockNixSvc := &mockNixService{
+				BuildAndRunScriptFunc: func(derivation string, opts nix.ScriptOptions) (nix.ScriptResult, error) {
+					exitCode := 0
+					if tt.status == types.StatusFailure {
+						exitCode = 1
+					}
+					if tt.status == types.StatusError {
+						return nix.ScriptResult{ExitCode: -1, WorkDir: workDir, ArtifactsDir: artifactsTmpDir}, errors.New("failed to start")
+					}
+					return nix.ScriptResult{ExitCode: exitCode, WorkDir: workDir, ArtifactsDir: artifactsTmpDir}, nil
+				},
+			}
+			r, err := New(Config{KeepTmp: tt.keepTmp}, mockNixSvc, &mockSnapshotService{})
+			if err != nil {
+				t.Fatalf("New() failed: %v", err)
+			}
+
+			result := r.executeTest(types.TestSpec{Name: "Script", Type: types.TestTypeScript, Script: "script.sh"})
+			if result.Status != tt.status {
+				t.Fatalf("executeTest() status = %s, want %s", result.Status, tt.status)
+			}
+
+			_, statErr := os.Stat(workDir)
+			if kept := statErr == nil; kept != tt.wantKept {
+				t.Errorf("work dir kept = %v, want %v", kept, tt.wantKept)
+			}
+			if tt.wantKept && result.WorkDir != workDir {
+				t.Errorf("result.WorkDir = %q, want %q", result.WorkDir, workDir)
+			}
+			if !tt.wantKept && result.WorkDir != "" {
+				t.Errorf("result.WorkDir = %q, want empty", result.WorkDir)
+			}
+
+			_, statErr = os.Stat(artifactsTmpDir)
+			if kept := statErr == nil; kept != tt.wantKept {
+				t.Errorf("artifacts dir kept = %v, want %v", kept, tt.wantKept)
+			}
+			if wantDir := map[bool]string{true: artifactsTmpDir}[tt.wantKept]; result.ArtifactsTmpDir != wantDir {
+				t.Errorf("result.ArtifactsTmpDir = %q, want %q", result.ArtifactsTmpDir, wantDir)
+			}
+		})
+	}
+}
+
+func TestRunner_collectArtifacts(t *testing.T) {
+	artifactsDir := t.TempDir()
+	scriptArtifacts, err := os.MkdirTemp(t.TempDir(), "nixtest-artifacts-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(scriptArtifacts, "screenshots"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(scriptArtifacts, "screenshots", "boot.png"), []byte("png"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	mockNixSvc := &mockNixService{
+		BuildAndRunScriptFunc: func(derivation string, opts nix.ScriptOptions) (nix.ScriptResult, error) {
+			return nix.ScriptResult{ExitCode: 1, ArtifactsDir: scriptArtifacts}, nil
+		},
+	}
+	r, err := New(Config{ArtifactsDir: artifactsDir}, mockNixSvc, &mockSnapshotService{})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+
+	result := r.executeTest(types.TestSpec{Suite: "Suite", Name: "VM test", Type: types.TestTypeScript, Script: "script.sh"})
+
+	wantArtifact := filepath.Join(artifactsDir, "Suite", "VM test", "screenshots", "boot.png")
+	if len(result.Artifacts) != 1 || result.Artifacts[0] != wantArtifact {
+		t.Errorf("result.Artifacts = %v, want [%s]", result.Artifacts, wantArtifact)
+	}
+	if content, err := os.ReadFile(wantArtifact); err != nil || string(content) != "png" {
+		t.Errorf("collected artifact content = %q, err = %v", content, err)
+	}
+	if _, err := os.Stat(scriptArtifacts); !os.IsNotExist(err) {
+		t.Errorf("temporary artifacts directory should be removed, stat err = %v", err)
+	}
+}
+
 func TestRunner_RunTests(t *testing.T) {
 	mockNixSvc := &mockNixService{}
 	mockSnapSvc := &mockSnapshotService{}
 
-	mockNixSvc.BuildAndParseJSONFunc = func(derivation string) (any, error) { return "parsed", nil }
-	mockNixSvc.BuildAndRunScriptFunc = func(derivation string, impureEnv bool) (int, string, string, error) { return 0, "", "", nil }
+	mockNixSvc.BuildAndParseFunc = func(derivation string, format types.Format) (any, error) { return "parsed", nil }
+	mockNixSvc.BuildAndRunScriptFunc = func(derivation string, opts nix.ScriptOptions) (nix.ScriptResult, error) {
+		return nix.ScriptResult{ExitCode: 0}, nil
+	}
 	mockSnapSvc.StatFunc = func(name string) (os.FileInfo, error) { return mockFileInfo{}, nil }
 	mockSnapSvc.LoadFileFunc = func(filePath string) (any, error) { return "snapshot", nil }
 	mockSnapSvc.CreateFileFunc = func(filePath string, data any) error { return nil }
@@ -388,3 +1167,72 @@ func TestRunner_RunTests(t *testing.T) {
 		t.Errorf("Not all tests were found in results map. S1T1:%v, S1T2:%v, S2T1:%v, S2T2:%v", foundS1T1, foundS1T2, foundS2T1, foundS2T2)
 	}
 }
+
+func TestRunner_RunTests_Verbose(t *testing.T) {
+	mockNixSvc := &mockNixService{}
+	mockNixSvc.BuildAndRunScriptFunc = func(derivation string, opts nix.ScriptOptions) (nix.ScriptResult, error) {
+		if opts.LiveStdout != nil {
+			fmt.Fprint(opts.LiveStdout, "line 1\nline 2")
+		}
+		if opts.LiveStderr != nil {
+			fmt.Fprint(opts.LiveStderr, "building\n")
+		}
+		return nix.ScriptResult{ExitCode: 0}, nil
+	}
+
+	tests := []struct {
+		name     string
+		verbose  bool
+		specs    []types.TestSpec
+		wantLive string
+	}{
+		{
+			name:     "Single test enables verbose",
+			specs:    []types.TestSpec{{Name: "only", Type: types.TestTypeScript}},
+			wantLive: "[only] line 1\n[only] building\n[only] line 2\n",
+		},
+		{
+			name: "Single selected test enables verbose",
+			specs: []types.TestSpec{
+				{Name: "only", Type: types.TestTypeScript},
+				{Name: "SkipThis", Type: types.TestTypeScript},
+			},
+			wantLive: "[only] line 1\n[only] building\n[only] line 2\n",
+		},
+		{
+			name: "Multiple tests without verbose",
+			specs: []types.TestSpec{
+				{Name: "first", Type: types.TestTypeScript},
+				{Name: "second", Type: types.TestTypeScript},
+			},
+			wantLive: "",
+		},
+		{
+			name:    "Multiple tests with verbose",
+			verbose: true,
+			specs: []types.TestSpec{
+				{Name: "first", Type: types.TestTypeScript},
+				{Name: "second", Type: types.TestTypeScript},
+			},
+			wantLive: "[first] line 1\n[first] building\n[first] line 2\n[second] line 1\n[second] building\n[second] line 2\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testRunner, err := New(Config{NumWorkers: 1, SkipPattern: "SkipThis", Verbose: tt.verbose}, mockNixSvc, &mockSnapshotService{})
+			if err != nil {
+				t.Fatalf("New() failed: %v", err)
+			}
+			var live strings.Builder
+			testRunner.liveOut = &live
+
+			testRunner.RunTests([]types.SuiteSpec{{Name: "suite", Tests: tt.specs}})
+
+			// with a single worker the order is deterministic, incomplete lines get flushed at the end
+			if live.String() != tt.wantLive {
+				t.Errorf("live output = %q, want %q", live.String(), tt.wantLive)
+			}
+		})
+	}
+}
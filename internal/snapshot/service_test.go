@@ -82,8 +82,14 @@ func TestDefaultService_CreateFileAndLoadFile(t *testing.T) {
 		if !ok {
 			t.Fatalf("LoadFile() did not return a map, got %T", loadedData)
 		}
-		if !reflect.DeepEqual(loadedMap, dataToWrite) {
-			t.Errorf("LoadFile() content mismatch. Got %v, want %v", loadedMap, dataToWrite)
+		// numbers are decoded exactly
+		wantData := map[string]any{
+			"name":   "test snapshot",
+			"value":  json.Number("42"),
+			"nested": map[string]any{"active": true},
+		}
+		if !reflect.DeepEqual(loadedMap, wantData) {
+			t.Errorf("LoadFile() content mismatch. Got %v, want %v", loadedMap, wantData)
 		}
 	})
 
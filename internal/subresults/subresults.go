@@ -0,0 +1,243 @@
+package subresults
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gitlab.com/TECHNOFAB/nixtest/internal/types"
+	"gitlab.com/TECHNOFAB/nixtest/internal/util"
+)
+
+var (
+	tapVersionRegex = regexp.MustCompile(`^TAP version \d+$`)
+	tapPlanRegex    = regexp.MustCompile(`^1\.\.(\d+)(\s*#.*)?$`)
+	tapTestRegex    = regexp.MustCompile(`^(not ok|ok)\b\s*(\d+)?\s*(?:-\s*)?([^#]*?)\s*(?:#\s*(.*))?$`)
+
+	vmSubtestRegex  = regexp.MustCompile(`^subtest: (.+)$`)
+	vmFinishedRegex = regexp.MustCompile(`^\(finished: subtest: (.+), in [\d.]+ seconds\)$`)
+	vmFailedRegex   = regexp.MustCompile(`^Test "(.+)" failed with error: "(.*)"$`)
+)
+
+// ParseTAP parses TAP (Test Anything Protocol) output into sub-results.
+// Output only counts as TAP if it contains a version or plan line, so
+// scripts which just happen to print "ok" are not misinterpreted.
+func ParseTAP(output string) (results []types.SubResult, isTAP bool) {
+	parser := NewTAPParser()
+	for _, line := range strings.Split(output, "\n") {
+		parser.Line(line)
+	}
+	return parser.Results()
+}
+
+// TAPParser parses TAP line by line, so output can be parsed while it's
+// written instead of keeping all of it (see ParseTAP)
+type TAPParser struct {
+	results []types.SubResult
+	planned int
+	inYAML  bool
+	isTAP   bool
+}
+
+func NewTAPParser() *TAPParser {
+	return &TAPParser{planned: -1}
+}
+
+// Line parses the next line of output
+func (p *TAPParser) Line(line string) {
+	line = strings.TrimRight(line, "\r")
+	trimmed := strings.TrimSpace(line)
+
+	if p.inYAML {
+		if trimmed == "..." {
+			p.inYAML = false
+		} else if len(p.results) > 0 {
+			appendMessage(&p.results[len(p.results)-1], strings.TrimPrefix(line, "  "))
+		}
+		return
+	}
+
+	switch {
+	case tapVersionRegex.MatchString(trimmed):
+		p.isTAP = true
+	case tapPlanRegex.MatchString(trimmed):
+		p.isTAP = true
+		p.planned, _ = strconv.Atoi(tapPlanRegex.FindStringSubmatch(trimmed)[1])
+	case strings.HasPrefix(trimmed, "Bail out!"):
+		p.results = append(p.results, types.SubResult{
+			Name:    "Bail out!",
+			Status:  types.StatusError,
+			Message: strings.TrimSpace(strings.TrimPrefix(trimmed, "Bail out!")),
+		})
+	case trimmed == "---" && len(p.results) > 0 && line != trimmed:
+		p.inYAML = true
+	case strings.HasPrefix(trimmed, "#") && len(p.results) > 0:
+		// diagnostics belong to the preceding failed test
+		last := &p.results[len(p.results)-1]
+		if last.Status == types.StatusFailure {
+			appendMessage(last, strings.TrimSpace(strings.TrimPrefix(trimmed, "#")))
+		}
+	case line == trimmed && tapTestRegex.MatchString(trimmed):
+		p.results = append(p.results, parseTAPTestLine(trimmed, len(p.results)+1))
+	}
+}
+
+// Results returns the sub-results of all lines so far, with an error if
+// they don't match the plan
+func (p *TAPParser) Results() (results []types.SubResult, isTAP bool) {
+	if !p.isTAP {
+		return nil, false
+	}
+	results = p.results
+	if p.planned >= 0 && p.planned != len(results) {
+		results = append(results[:len(results):len(results)], types.SubResult{
+			Name:    "plan",
+			Status:  types.StatusError,
+			Message: fmt.Sprintf("planned %d tests but got %d", p.planned, len(results)),
+		})
+	}
+	return results, true
+}
+
+func parseTAPTestLine(line string, index int) types.SubResult {
+	match := tapTestRegex.FindStringSubmatch(line)
+	ok, number, description, directive := match[1] == "ok", match[2], match[3], match[4]
+
+	name := description
+	if name == "" {
+		if number == "" {
+			number = strconv.Itoa(index)
+		}
+		name = "#" + number
+	}
+
+	result := types.SubResult{Name: name, Status: types.StatusSuccess}
+	if !ok {
+		result.Status = types.StatusFailure
+	}
+
+	upperDirective := strings.ToUpper(directive)
+	switch {
+	case strings.HasPrefix(upperDirective, "SKIP"):
+		result.Status = types.StatusSkipped
+		result.Message = strings.TrimSpace(directive[len("SKIP"):])
+	case strings.HasPrefix(upperDirective, "TODO"):
+		// failing TODO tests are expected to fail and don't count
+		if !ok {
+			result.Status = types.StatusSkipped
+		}
+		result.Message = strings.TrimSpace(directive[len("TODO"):])
+	}
+	return result
+}
+
+func appendMessage(result *types.SubResult, line string) {
+	if result.Message == "" {
+		result.Message = line
+		return
+	}
+	result.Message += "\n" + line
+}
+
+type jsonLine struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+// ParseJSONLines parses sub-results written as one JSON object per line, like
+// {"name": "...", "status": "pass|fail|skip|error", "message": "..."}
+func ParseJSONLines(data string) ([]types.SubResult, error) {
+	var results []types.SubResult
+	for i, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		var entry jsonLine
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+
+		var status types.TestStatus
+		switch strings.ToLower(entry.Status) {
+		case "pass", "ok", "success":
+			status = types.StatusSuccess
+		case "fail", "failure":
+			status = types.StatusFailure
+		case "skip", "skipped":
+			status = types.StatusSkipped
+		case "error":
+			status = types.StatusError
+		default:
+			return nil, fmt.Errorf("line %d: invalid status %q", i+1, entry.Status)
+		}
+
+		results = append(results, types.SubResult{Name: entry.Name, Status: status, Message: entry.Message})
+	}
+	return results, nil
+}
+
+// ParseNixOSTest parses the subtest markers the NixOS test driver logs for
+// every `with subtest("..."):` block into sub-results. Subtests which were
+// started but neither finished nor failed (e.g. the driver crashed) are errors.
+func ParseNixOSTest(output string) []types.SubResult {
+	parser := NewNixOSTestParser()
+	for _, line := range strings.Split(output, "\n") {
+		parser.Line(line)
+	}
+	return parser.Results()
+}
+
+// NixOSTestParser parses the subtest markers line by line, so the driver's
+// output can be parsed while it's written (see ParseNixOSTest)
+type NixOSTestParser struct {
+	results []types.SubResult
+	running map[string]int
+}
+
+func NewNixOSTestParser() *NixOSTestParser {
+	return &NixOSTestParser{running: map[string]int{}}
+}
+
+// Line parses the next line of output
+func (p *NixOSTestParser) Line(line string) {
+	line = strings.TrimSpace(util.StripANSI(line))
+	if match := vmFinishedRegex.FindStringSubmatch(line); match != nil {
+		delete(p.running, match[1])
+	} else if match := vmFailedRegex.FindStringSubmatch(line); match != nil {
+		if i, ok := p.running[match[1]]; ok {
+			p.results[i].Status = types.StatusFailure
+			p.results[i].Message = match[2]
+			delete(p.running, match[1])
+		}
+	} else if match := vmSubtestRegex.FindStringSubmatch(line); match != nil {
+		p.running[match[1]] = len(p.results)
+		p.results = append(p.results, types.SubResult{Name: match[1], Status: types.StatusSuccess})
+	}
+}
+
+// Results returns the sub-results of all lines so far, running subtests are errors
+func (p *NixOSTestParser) Results() []types.SubResult {
+	results := slices.Clone(p.results)
+	for _, i := range p.running {
+		results[i].Status = types.StatusError
+		results[i].Message = "subtest did not finish"
+	}
+	return results
+}
+
+// CountFailed returns how many sub-results failed or errored
+func CountFailed(results []types.SubResult) int {
+	failed := 0
+	for _, result := range results {
+		if result.Status == types.StatusFailure || result.Status == types.StatusError {
+			failed++
+		}
+	}
+	return failed
+}
@@ -0,0 +1,144 @@
+package subresults
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"gitlab.com/TECHNOFAB/nixtest/internal/types"
+)
+
+func TestParseTAP(t *testing.T) {
+	tests := []struct {
+		name      string
+		output    string
+		wantIsTAP bool
+		want      []types.SubResult
+	}{
+		{
+			name:      "Not TAP without plan",
+			output:    "ok everything worked\n",
+			wantIsTAP: false,
+		},
+		{
+			name:      "Passing and failing tests",
+			output:    "TAP version 13\n1..3\nok 1 - first\nnot ok 2 - second\n# expected 1\n# got 2\nok 3 - third # SKIP not on linux\n",
+			wantIsTAP: true,
+			want: []types.SubResult{
+				{Name: "first", Status: types.StatusSuccess},
+				{Name: "second", Status: types.StatusFailure, Message: "expected 1\ngot 2"},
+				{Name: "third", Status: types.StatusSkipped, Message: "not on linux"},
+			},
+		},
+		{
+			name:      "YAML diagnostics and TODO",
+			output:    "1..2\nnot ok 1 - with yaml\n  ---\n  message: boom\n  ...\nnot ok 2 - later # TODO implement\n",
+			wantIsTAP: true,
+			want: []types.SubResult{
+				{Name: "with yaml", Status: types.StatusFailure, Message: "message: boom"},
+				{Name: "later", Status: types.StatusSkipped, Message: "implement"},
+			},
+		},
+		{
+			name:      "Missing descriptions and plan mismatch",
+			output:    "ok 1\nok\n1..3\n",
+			wantIsTAP: true,
+			want: []types.SubResult{
+				{Name: "#1", Status: types.StatusSuccess},
+				{Name: "#2", Status: types.StatusSuccess},
+				{Name: "plan", Status: types.StatusError, Message: "planned 3 tests but got 2"},
+			},
+		},
+		{
+			name:      "Bail out",
+			output:    "1..1\nBail out! database missing\n",
+			wantIsTAP: true,
+			want: []types.SubResult{
+				{Name: "Bail out!", Status: types.StatusError, Message: "database missing"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, isTAP := ParseTAP(tt.output)
+			if isTAP != tt.wantIsTAP {
+				t.Fatalf("ParseTAP() isTAP = %v, want %v", isTAP, tt.wantIsTAP)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseTAP() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseJSONLines(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    []types.SubResult
+		wantErr bool
+	}{
+		{"Empty", "", nil, false},
+		{
+			"Valid lines",
+			"{\"name\": \"a\", \"status\": \"pass\"}\n\n{\"name\": \"b\", \"status\": \"fail\", \"message\": \"nope\"}\n{\"name\": \"c\", \"status\": \"skip\"}\n",
+			[]types.SubResult{
+				{Name: "a", Status: types.StatusSuccess},
+				{Name: "b", Status: types.StatusFailure, Message: "nope"},
+				{Name: "c", Status: types.StatusSkipped},
+			},
+			false,
+		},
+		{"Invalid JSON", "{\"name\": ", nil, true},
+		{"Invalid status", "{\"name\": \"a\", \"status\": \"maybe\"}", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseJSONLines(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseJSONLines() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseJSONLines() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseNixOSTest(t *testing.T) {
+	output := strings.Join([]string{
+		"start all VMs",
+		"\x1b[1m\x1b[32msubtest: nginx starts\x1b[0m",
+		"machine: waiting for unit nginx.service",
+		"machine # [    1.234] nginx[123]: started",
+		"(finished: subtest: nginx starts, in 3.21 seconds)",
+		"subtest: port is open",
+		`Test "port is open" failed with error: "timed out waiting for port 80"`,
+		"subtest: never finishes",
+		"kill machine (pid 42)",
+	}, "\n")
+
+	want := []types.SubResult{
+		{Name: "nginx starts", Status: types.StatusSuccess},
+		{Name: "port is open", Status: types.StatusFailure, Message: "timed out waiting for port 80"},
+		{Name: "never finishes", Status: types.StatusError, Message: "subtest did not finish"},
+	}
+	if got := ParseNixOSTest(output); !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseNixOSTest() = %#v, want %#v", got, want)
+	}
+	if got := ParseNixOSTest("no subtests here\n"); got != nil {
+		t.Errorf("ParseNixOSTest() = %#v, want nil", got)
+	}
+}
+
+func TestCountFailed(t *testing.T) {
+	results := []types.SubResult{
+		{Status: types.StatusSuccess},
+		{Status: types.StatusFailure},
+		{Status: types.StatusError},
+		{Status: types.StatusSkipped},
+	}
+	if got := CountFailed(results); got != 2 {
+		t.Errorf("CountFailed() = %d, want 2", got)
+	}
+}
@@ -0,0 +1,89 @@
+package tree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"unicode/utf8"
+)
+
+// MaxContentSize is the size above which files are recorded by hash instead of content
+const MaxContentSize = 64 * 1024
+
+// Read returns the file or directory tree at root as snapshot value: a map of
+// slash separated paths relative to root (root itself is ".") to their entry.
+// Entries have a "type" (file, directory or symlink), files and directories a
+// "mode" (their permissions in octal) and symlinks a "target". Files contain
+// either their "content" if it's text of at most MaxContentSize bytes or
+// their "sha256" and "size" otherwise.
+func Read(root string) (map[string]any, error) {
+	entries := make(map[string]any)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		entry, err := readEntry(path, d)
+		if err != nil {
+			return err
+		}
+		entries[filepath.ToSlash(rel)] = entry
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tree %s: %w", root, err)
+	}
+	return entries, nil
+}
+
+func readEntry(path string, d fs.DirEntry) (map[string]any, error) {
+	info, err := d.Info()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case info.Mode()&fs.ModeSymlink != 0:
+		target, err := os.Readlink(path)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"type": "symlink", "target": target}, nil
+	case info.IsDir():
+		return map[string]any{"type": "directory", "mode": formatMode(info.Mode())}, nil
+	case !info.Mode().IsRegular():
+		return nil, fmt.Errorf("%s is not a regular file, directory or symlink", path)
+	}
+
+	entry := map[string]any{"type": "file", "mode": formatMode(info.Mode())}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) <= MaxContentSize && isText(data) {
+		entry["content"] = string(data)
+	} else {
+		sum := sha256.Sum256(data)
+		entry["sha256"] = hex.EncodeToString(sum[:])
+		// json.Number like numbers parsed from snapshots, so exact comparisons work
+		entry["size"] = json.Number(strconv.Itoa(len(data)))
+	}
+	return entry, nil
+}
+
+func formatMode(mode fs.FileMode) string {
+	return fmt.Sprintf("%04o", mode.Perm())
+}
+
+func isText(data []byte) bool {
+	return utf8.Valid(data) && !bytes.ContainsRune(data, 0)
+}
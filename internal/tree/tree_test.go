@@ -0,0 +1,109 @@
+package tree
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRead(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(t *testing.T, root string) string
+		want    map[string]any
+		wantErr string
+	}{
+		{
+			name: "Directory",
+			setup: func(t *testing.T, root string) string {
+				mustMkdir(t, filepath.Join(root, "out", "bin"), 0755)
+				mustWrite(t, filepath.Join(root, "out", "bin", "app"), "#!/bin/sh\necho hi\n", 0755)
+				mustWrite(t, filepath.Join(root, "out", "app.conf"), "port = 80\n", 0644)
+				mustWrite(t, filepath.Join(root, "out", "logo.png"), "\x89PNG\x00\x01", 0644)
+				if err := os.Symlink("bin/app", filepath.Join(root, "out", "link")); err != nil {
+					t.Fatal(err)
+				}
+				return filepath.Join(root, "out")
+			},
+			want: map[string]any{
+				".":        map[string]any{"type": "directory", "mode": "0755"},
+				"bin":      map[string]any{"type": "directory", "mode": "0755"},
+				"bin/app":  map[string]any{"type": "file", "mode": "0755", "content": "#!/bin/sh\necho hi\n"},
+				"app.conf": map[string]any{"type": "file", "mode": "0644", "content": "port = 80\n"},
+				"logo.png": map[string]any{"type": "file", "mode": "0644", "size": json.Number("6"), "sha256": "09824c6bec844d272dd1a01cd876409dfaf7b650d0ec2fc2e88a1e6a8596f762"},
+				"link":     map[string]any{"type": "symlink", "target": "bin/app"},
+			},
+		},
+		{
+			name: "Single file",
+			setup: func(t *testing.T, root string) string {
+				path := filepath.Join(root, "out")
+				mustWrite(t, path, "hello", 0444)
+				return path
+			},
+			want: map[string]any{
+				".": map[string]any{"type": "file", "mode": "0444", "content": "hello"},
+			},
+		},
+		{
+			name: "Large text file",
+			setup: func(t *testing.T, root string) string {
+				path := filepath.Join(root, "out")
+				mustWrite(t, path, strings.Repeat("a", MaxContentSize+1), 0444)
+				return path
+			},
+			want: map[string]any{
+				".": map[string]any{"type": "file", "mode": "0444", "size": json.Number("65537"), "sha256": "008ffc88d3c96a9f307524eb361e47c5222a887fc45fa0c1fb8d429c5c23b430"},
+			},
+		},
+		{
+			name: "Missing root",
+			setup: func(t *testing.T, root string) string {
+				return filepath.Join(root, "missing")
+			},
+			wantErr: "failed to read tree",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := tt.setup(t, t.TempDir())
+			got, err := Read(root)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Read() error = %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Read() unexpected error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Read() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func mustMkdir(t *testing.T, path string, perm os.FileMode) {
+	t.Helper()
+	if err := os.MkdirAll(path, perm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(path, perm); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func mustWrite(t *testing.T, path string, content string, perm os.FileMode) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), perm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(path, perm); err != nil {
+		t.Fatal(err)
+	}
+}
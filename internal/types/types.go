@@ -5,9 +5,62 @@ import "time"
 type TestType string
 
 const (
-	TestTypeScript   TestType = "script"
-	TestTypeUnit     TestType = "unit"
-	TestTypeSnapshot TestType = "snapshot"
+	TestTypeScript     TestType = "script"
+	TestTypeUnit       TestType = "unit"
+	TestTypeSnapshot   TestType = "snapshot"
+	TestTypeVM         TestType = "vm"
+	TestTypeEval       TestType = "eval"
+	TestTypeThrows     TestType = "throws"
+	TestTypeBuild      TestType = "build"
+	TestTypeBuildFails TestType = "buildFails"
+	TestTypeTree       TestType = "tree"
+)
+
+type MatchMode string
+
+const (
+	MatchExact  MatchMode = "exact"
+	MatchSubset MatchMode = "subset"
+)
+
+// Comparison loosens how values are compared
+type Comparison string
+
+const (
+	CompareIgnoreWhitespace      Comparison = "ignoreWhitespace"
+	CompareIgnoreCase            Comparison = "ignoreCase"
+	CompareIgnoreTrailingNewline Comparison = "ignoreTrailingNewline"
+	CompareUnorderedLists        Comparison = "unorderedLists"
+)
+
+// NumberMode controls how JSON numbers are compared
+type NumberMode string
+
+const (
+	// NumbersFloat compares numbers as float64, large integers may be rounded
+	NumbersFloat NumberMode = "float"
+	// NumbersExact compares numbers exactly as decoded (json.Number)
+	NumbersExact NumberMode = "exact"
+)
+
+// Format is the format of the output of an actualDrv
+type Format string
+
+const (
+	FormatJSON Format = "json"
+	// FormatText compares the output as a single string
+	FormatText Format = "text"
+	FormatYAML Format = "yaml"
+	FormatTOML Format = "toml"
+)
+
+// StorePaths controls how /nix/store paths in compared values are handled
+type StorePaths string
+
+const (
+	StorePathsKeep StorePaths = "keep"
+	// StorePathsNormalize replaces the hashes of store paths with a placeholder
+	StorePathsNormalize StorePaths = "normalize"
 )
 
 type SuiteSpec struct {
@@ -16,18 +69,58 @@ type SuiteSpec struct {
 }
 
 type TestSpec struct {
-	Type        TestType `json:"type"`
-	Name        string   `json:"name"`
-	Description string   `json:"description"`
-	Expected    any      `json:"expected,omitempty"`
-	Actual      any      `json:"actual,omitempty"`
-	ActualDrv   string   `json:"actualDrv,omitempty"`
-	Script      string   `json:"script,omitempty"`
-	Pos         string   `json:"pos,omitempty"`
+	Type         TestType       `json:"type"`
+	Name         string         `json:"name"`
+	Description  string         `json:"description"`
+	Expected     any            `json:"expected,omitempty"`
+	Actual       any            `json:"actual,omitempty"`
+	ActualDrv    string         `json:"actualDrv,omitempty"`
+	ActualFormat Format         `json:"actualFormat,omitempty"`
+	Flake        string         `json:"flake,omitempty"`
+	File         string         `json:"file,omitempty"`
+	Attr         string         `json:"attr,omitempty"`
+	Derivation   string         `json:"derivation,omitempty"`
+	Contains     string         `json:"contains,omitempty"`
+	Pattern      string         `json:"pattern,omitempty"`
+	Match        MatchMode      `json:"match,omitempty"`
+	SubsetLists  bool           `json:"subsetLists,omitempty"`
+	Comparison   []Comparison   `json:"comparison,omitempty"`
+	Tolerance    Tolerance      `json:"tolerance"`
+	Numbers      NumberMode     `json:"numbers,omitempty"`
+	Ignore       []string       `json:"ignore,omitempty"`
+	Redact       []string       `json:"redact,omitempty"`
+	StorePaths   StorePaths     `json:"storePaths,omitempty"`
+	Script       string         `json:"script,omitempty"`
+	Fixtures     string         `json:"fixtures,omitempty"`
+	Interpreter  string         `json:"interpreter,omitempty"`
+	Entrypoint   string         `json:"entrypoint,omitempty"`
+	Args         []string       `json:"args,omitempty"`
+	Stdin        string         `json:"stdin,omitempty"`
+	Limits       ResourceLimits `json:"limits"`
+	Driver       string         `json:"driver,omitempty"`
+	Pos          string         `json:"pos,omitempty"`
 
 	Suite string
 }
 
+// Tolerance allows numbers to differ slightly, zero means exact
+type Tolerance struct {
+	// Absolute is the maximum difference
+	Absolute float64 `json:"absolute,omitempty"`
+	// Relative is the maximum difference relative to the larger magnitude
+	Relative float64 `json:"relative,omitempty"`
+}
+
+// ResourceLimits restricts the resources a script test may use, zero means unlimited
+type ResourceLimits struct {
+	// Memory is the maximum address space in bytes
+	Memory int64 `json:"memory,omitempty"`
+	// CPUTime is the maximum CPU time in seconds
+	CPUTime int64 `json:"cpuTime,omitempty"`
+	// OpenFiles is the maximum number of open file descriptors
+	OpenFiles int64 `json:"openFiles,omitempty"`
+}
+
 type TestStatus int
 
 const (
@@ -52,6 +145,37 @@ func (ts TestStatus) String() string {
 	}
 }
 
+// SubResult is a single assertion reported by a script test, either as TAP
+// on stdout or as JSON lines written to $NIXTEST_RESULTS
+type SubResult struct {
+	Name    string
+	Status  TestStatus
+	Message string
+}
+
+type MismatchKind int
+
+const (
+	// MismatchChanged means the value at Path differs
+	MismatchChanged MismatchKind = iota
+	// MismatchAdded means the value at Path only exists in actual
+	MismatchAdded
+	// MismatchRemoved means the value at Path only exists in expected
+	MismatchRemoved
+)
+
+// Mismatch is a single difference between expected and actual, Path is a
+// JSON path like $.foo.bar[0]
+type Mismatch struct {
+	Kind    MismatchKind
+	Path    string
+	Message string
+	// Expected and Actual are set for changed multi-line strings below the
+	// root, which are shown as text diff
+	Expected string
+	Actual   string
+}
+
 type TestResult struct {
 	Spec         TestSpec
 	Status       TestStatus
@@ -59,6 +183,21 @@ type TestResult struct {
 	ErrorMessage string
 	Expected     string
 	Actual       string
+	// Mismatches explain where and why actual differs from expected
+	Mismatches []Mismatch
+	// WorkDir is the kept working directory of a script test (see --keep-tmp)
+	WorkDir string
+	// ArtifactsTmpDir is the kept $NIXTEST_ARTIFACTS directory of a script or VM
+	// test (see --keep-tmp), VM tests write screenshots and serial logs there
+	ArtifactsTmpDir string
+	// Artifacts are the collected files a script test wrote to $NIXTEST_ARTIFACTS
+	Artifacts []string
+	// SubResults are the assertions reported by a script test
+	SubResults []SubResult
+	// PeakRSS is the maximum resident set size of a script test in bytes
+	PeakRSS int64
+	// CPUTime is the user + system CPU time used by a script test
+	CPUTime time.Duration
 }
 
 type Results map[string][]TestResult
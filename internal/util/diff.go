@@ -0,0 +1,504 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/akedrou/textdiff"
+	"github.com/jedib0t/go-pretty/v6/text"
+)
+
+// DiffStyle selects how the difference of a failed test is shown
+type DiffStyle string
+
+const (
+	// DiffStructural lists the mismatching paths, values without structure
+	// (like strings) fall back to DiffUnified
+	DiffStructural DiffStyle = "structural"
+	DiffUnified    DiffStyle = "unified"
+	DiffSideBySide DiffStyle = "side-by-side"
+)
+
+// DefaultDiffContext is the default amount of unchanged lines around changes
+const DefaultDiffContext = 3
+
+// width of side-by-side diffs if unknown
+const defaultDiffWidth = 120
+
+// DiffOptions control how diffs are rendered
+type DiffOptions struct {
+	// Style defaults to DiffStructural
+	Style DiffStyle
+	// Context is the amount of unchanged lines shown around changes
+	Context int
+	// Color highlights the diff with ANSI colors. Without it intra-line
+	// changes are marked by "?" guide lines below the changed lines instead
+	Color bool
+	// Width is the total width of side-by-side diffs, defaults to 120
+	Width int
+	// MaxLines caps the rendered lines, the rest is summarized. 0 disables it
+	MaxLines int
+}
+
+// intra-line change levels of a single rune
+const (
+	unchanged = iota
+	changedWord
+	changedChar
+)
+
+var (
+	removedStyle     = text.Colors{text.FgRed}
+	removedWordStyle = text.Colors{text.FgRed, text.Bold, text.Underline}
+	removedCharStyle = text.Colors{text.BgRed, text.FgBlack}
+	addedStyle       = text.Colors{text.FgGreen}
+	addedWordStyle   = text.Colors{text.FgGreen, text.Bold, text.Underline}
+	addedCharStyle   = text.Colors{text.BgGreen, text.FgBlack}
+	hunkStyle        = text.Colors{text.FgCyan}
+	headerStyle      = text.Colors{text.Bold}
+	noteStyle        = text.Colors{text.FgYellow}
+)
+
+type diffLine struct {
+	op   byte
+	text string
+	// levels is the intra-line change level of every rune of text, nil if
+	// the line has no counterpart on the other side
+	levels []int
+}
+
+// RenderDiff renders a unified diff of expected and actual where changed
+// lines are highlighted by word and character. Changed whitespace is made
+// visible (· for spaces, → for tabs) and a differing trailing newline is noted.
+func RenderDiff(expected, actual string, opts DiffOptions) (string, error) {
+	lines, err := diffLines(expected, actual, opts.Context)
+	if err != nil || lines == nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	for _, line := range lines {
+		renderLine(&sb, line, opts)
+	}
+	writeNewlineNote(&sb, expected, actual, opts)
+	return sb.String(), nil
+}
+
+// RenderSideBySide renders expected and actual next to each other, fitted to
+// opts.Width with long lines wrapped. Changed lines are marked with "|",
+// removed ones with "<" and added ones with ">", highlighting works like in
+// RenderDiff.
+func RenderSideBySide(expected, actual string, opts DiffOptions) (string, error) {
+	lines, err := diffLines(expected, actual, opts.Context)
+	if err != nil || lines == nil {
+		return "", err
+	}
+
+	width := opts.Width
+	if width <= 0 {
+		width = defaultDiffWidth
+	}
+	sbs := sideBySide{opts: opts, column: max((width-3)/2, 10)}
+
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		switch line.op {
+		case 'h':
+			if strings.HasPrefix(line.text, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1].text, "+++ ") {
+				sbs.header(strings.TrimPrefix(line.text, "--- "), strings.TrimPrefix(lines[i+1].text, "+++ "))
+				i += 2
+				continue
+			}
+			sbs.fullRow(line.text, hunkStyle)
+			i++
+		case ' ':
+			sbs.pair(line, line, ' ')
+			i++
+		default:
+			var removed, added []*diffLine
+			for ; i < len(lines) && lines[i].op == '-'; i++ {
+				removed = append(removed, lines[i])
+			}
+			for ; i < len(lines) && lines[i].op == '+'; i++ {
+				added = append(added, lines[i])
+			}
+			for k := 0; k < max(len(removed), len(added)); k++ {
+				switch {
+				case k >= len(added):
+					sbs.pair(removed[k], nil, '<')
+				case k >= len(removed):
+					sbs.pair(nil, added[k], '>')
+				default:
+					sbs.pair(removed[k], added[k], '|')
+				}
+			}
+		}
+	}
+	writeNewlineNote(&sbs.sb, expected, actual, opts)
+	return sbs.sb.String(), nil
+}
+
+// LimitLines keeps the first max lines of s and summarizes the rest, max <= 0
+// keeps everything
+func LimitLines(s string, max int) string {
+	if max <= 0 {
+		return s
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) <= max {
+		return s
+	}
+	return strings.Join(lines[:max], "") + fmt.Sprintf("... %d more lines not shown\n", len(lines)-max)
+}
+
+// diffLines returns the parsed and highlighted lines of the unified diff of
+// expected and actual, nil if they are equal
+func diffLines(expected, actual string, context int) ([]*diffLine, error) {
+	diff, err := ComputeDiff(expected, actual, context)
+	if err != nil || diff == "" {
+		return nil, err
+	}
+	lines := parseUnified(diff)
+	highlightChanges(lines)
+	return lines, nil
+}
+
+func parseUnified(diff string) []*diffLine {
+	var lines []*diffLine
+	for _, raw := range strings.Split(strings.TrimSuffix(diff, "\n"), "\n") {
+		if strings.HasPrefix(raw, "--- ") || strings.HasPrefix(raw, "+++ ") || strings.HasPrefix(raw, "@@") {
+			lines = append(lines, &diffLine{op: 'h', text: raw})
+			continue
+		}
+		if raw == "" {
+			// an empty context line whose leading space got lost
+			lines = append(lines, &diffLine{op: ' '})
+			continue
+		}
+		lines = append(lines, &diffLine{op: raw[0], text: raw[1:]})
+	}
+	return lines
+}
+
+// highlightChanges pairs the removed and added lines of every change block
+// by position and computes their intra-line change levels
+func highlightChanges(lines []*diffLine) {
+	for i := 0; i < len(lines); {
+		if lines[i].op != '-' {
+			i++
+			continue
+		}
+		start := i
+		for i < len(lines) && lines[i].op == '-' {
+			i++
+		}
+		middle := i
+		for i < len(lines) && lines[i].op == '+' {
+			i++
+		}
+		for k := 0; start+k < middle && middle+k < i; k++ {
+			removed, added := lines[start+k], lines[middle+k]
+			removed.levels, added.levels = intraLineLevels(removed.text, added.text)
+		}
+	}
+}
+
+// intraLineLevels returns the change level of every rune of before and after.
+// Changed characters are changedChar, the rest of words containing them
+// changedWord (or changedChar too if most of the word changed). Lines which
+// have less than half in common are not highlighted, they were replaced.
+func intraLineLevels(before, after string) ([]int, []int) {
+	beforeBytes := make([]int, len(before)+1)
+	afterBytes := make([]int, len(after)+1)
+	common := len(before)
+	delta := 0
+	for _, edit := range textdiff.Strings(before, after) {
+		start := edit.Start + delta
+		end := start + len(edit.New)
+		markBytes(beforeBytes, edit.Start, edit.End)
+		markBytes(afterBytes, start, end)
+		common -= edit.End - edit.Start
+		delta += len(edit.New) - (edit.End - edit.Start)
+	}
+	if common*2 < max(len(before), len(after)) {
+		return nil, nil
+	}
+	return runeLevels(before, beforeBytes), runeLevels(after, afterBytes)
+}
+
+// markBytes marks bytes[start:end] as changed, or the insertion point at
+// start (stored as changedWord) if the range is empty
+func markBytes(bytes []int, start, end int) {
+	if start == end {
+		if bytes[start] == unchanged {
+			bytes[start] = changedWord
+		}
+		return
+	}
+	for i := start; i < end; i++ {
+		bytes[i] = changedChar
+	}
+}
+
+func runeLevels(line string, bytes []int) []int {
+	runes := []rune(line)
+	levels := make([]int, len(runes))
+	// insertion points mark the word around them without changing a rune
+	inserted := make([]bool, len(runes))
+	offset := 0
+	for i, r := range runes {
+		switch bytes[offset] {
+		case changedChar:
+			levels[i] = changedChar
+		case changedWord:
+			inserted[i] = true
+		}
+		offset += utf8.RuneLen(r)
+	}
+
+	for start := 0; start < len(runes); {
+		if !isWordRune(runes[start]) {
+			start++
+			continue
+		}
+		end := start
+		for end < len(runes) && isWordRune(runes[end]) {
+			end++
+		}
+		// insertions only touch a word if they split it, not at its boundaries
+		touched, changed := false, 0
+		for i := start; i < end; i++ {
+			if levels[i] == changedChar {
+				changed++
+			}
+			touched = touched || levels[i] == changedChar || (i > start && inserted[i])
+		}
+		// words which mostly changed are highlighted as a whole, scattered
+		// single characters are harder to read than the word
+		wordLevel := changedWord
+		if changed*2 > end-start {
+			wordLevel = changedChar
+		}
+		if touched {
+			for i := start; i < end; i++ {
+				if levels[i] == unchanged {
+					levels[i] = wordLevel
+				}
+			}
+		}
+		start = end
+	}
+	return levels
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func renderLine(sb *strings.Builder, line *diffLine, opts DiffOptions) {
+	switch line.op {
+	case 'h':
+		style := headerStyle
+		if strings.HasPrefix(line.text, "@@") {
+			style = hunkStyle
+		}
+		sb.WriteString(colorize(line.text, style, opts.Color) + "\n")
+		return
+	case ' ':
+		sb.WriteString(" " + line.text + "\n")
+		return
+	}
+
+	styles := lineStyles(line.op)
+	runes := []rune(line.text)
+	sb.WriteString(colorize(string(line.op), styles[unchanged], opts.Color))
+	sb.WriteString(renderSegments(runes, line.levels, styles, opts.Color) + "\n")
+
+	if !opts.Color {
+		if guide := guideMarks(runes, line.levels); guide != "" {
+			sb.WriteString("?" + guide + "\n")
+		}
+	}
+}
+
+func lineStyles(op byte) [3]text.Colors {
+	if op == '+' {
+		return [3]text.Colors{addedStyle, addedWordStyle, addedCharStyle}
+	}
+	return [3]text.Colors{removedStyle, removedWordStyle, removedCharStyle}
+}
+
+// renderSegments renders runes styled by their change level, levels may be
+// nil if nothing is highlighted
+func renderSegments(runes []rune, levels []int, styles [3]text.Colors, color bool) string {
+	if levels == nil {
+		return colorize(string(runes), styles[unchanged], color)
+	}
+	var sb strings.Builder
+	for start := 0; start < len(runes); {
+		level := levels[start]
+		end := start
+		for end < len(runes) && levels[end] == level {
+			end++
+		}
+		segment := string(runes[start:end])
+		if level == changedChar {
+			segment = showWhitespace(segment)
+		}
+		sb.WriteString(colorize(segment, styles[level], color))
+		start = end
+	}
+	return sb.String()
+}
+
+func colorize(s string, style text.Colors, color bool) string {
+	if !color || s == "" {
+		return s
+	}
+	return style.Sprint(s)
+}
+
+// guideMarks marks changed characters with "^" and the rest of changed words
+// with "~", like the "?" lines of Python's difflib.ndiff
+func guideMarks(runes []rune, levels []int) string {
+	if levels == nil {
+		return ""
+	}
+	var sb strings.Builder
+	for i, r := range runes {
+		switch {
+		case levels[i] == changedChar:
+			sb.WriteString("^")
+		case levels[i] == changedWord:
+			sb.WriteString("~")
+		case r == '\t':
+			// keep the alignment of unchanged tabs
+			sb.WriteString("\t")
+		default:
+			sb.WriteString(" ")
+		}
+	}
+	return strings.TrimRight(sb.String(), " \t")
+}
+
+// sideBySide collects the rows of a side-by-side diff
+type sideBySide struct {
+	sb     strings.Builder
+	opts   DiffOptions
+	column int
+}
+
+func (s *sideBySide) header(left, right string) {
+	row := padRight(truncate(left, s.column), s.column) + "   " + truncate(right, s.column)
+	s.sb.WriteString(colorize(row, headerStyle, s.opts.Color) + "\n")
+}
+
+func (s *sideBySide) fullRow(line string, style text.Colors) {
+	s.sb.WriteString(colorize(line, style, s.opts.Color) + "\n")
+}
+
+// pair renders two lines next to each other, wrapped to the column width.
+// Either line may be nil if it only exists on one side.
+func (s *sideBySide) pair(left, right *diffLine, marker rune) {
+	leftRunes, leftLevels := cellContent(left)
+	rightRunes, rightLevels := cellContent(right)
+	rows := max((len(leftRunes)+s.column-1)/s.column, (len(rightRunes)+s.column-1)/s.column, 1)
+
+	markerStyle := noteStyle
+	switch marker {
+	case '<':
+		markerStyle = removedStyle
+	case '>':
+		markerStyle = addedStyle
+	}
+
+	for row := 0; row < rows; row++ {
+		leftCell, leftGuide := s.cell(left, leftRunes, leftLevels, row)
+		rightCell, rightGuide := s.cell(right, rightRunes, rightLevels, row)
+		rowMarker := " "
+		if row == 0 {
+			rowMarker = colorize(string(marker), markerStyle, s.opts.Color)
+		}
+		s.sb.WriteString(strings.TrimRight(leftCell+" "+rowMarker+" "+rightCell, " ") + "\n")
+		if leftGuide != "" || rightGuide != "" {
+			s.sb.WriteString(strings.TrimRight(padRight(leftGuide, s.column)+" ? "+rightGuide, " ") + "\n")
+		}
+	}
+}
+
+// cell renders the given wrapped row of a line padded to the column width,
+// with its guide marks if colors are disabled
+func (s *sideBySide) cell(line *diffLine, runes []rune, levels []int, row int) (string, string) {
+	start := min(row*s.column, len(runes))
+	end := min(start+s.column, len(runes))
+	if line == nil || start == end {
+		return strings.Repeat(" ", s.column), ""
+	}
+	var rowLevels []int
+	if levels != nil {
+		rowLevels = levels[start:end]
+	}
+	padding := strings.Repeat(" ", s.column-(end-start))
+	if line.op == ' ' {
+		return string(runes[start:end]) + padding, ""
+	}
+	content := renderSegments(runes[start:end], rowLevels, lineStyles(line.op), s.opts.Color)
+	if s.opts.Color {
+		return content + padding, ""
+	}
+	return content + padding, guideMarks(runes[start:end], rowLevels)
+}
+
+// cellContent returns the runes of a line for a side-by-side cell, unchanged
+// tabs are replaced by spaces to keep the columns aligned
+func cellContent(line *diffLine) ([]rune, []int) {
+	if line == nil {
+		return nil, nil
+	}
+	runes := []rune(line.text)
+	for i, r := range runes {
+		if r == '\t' && (line.levels == nil || line.levels[i] != changedChar) {
+			runes[i] = ' '
+		}
+	}
+	return runes, line.levels
+}
+
+func padRight(s string, width int) string {
+	if n := utf8.RuneCountInString(s); n < width {
+		return s + strings.Repeat(" ", width-n)
+	}
+	return s
+}
+
+func truncate(s string, width int) string {
+	if runes := []rune(s); len(runes) > width {
+		return string(runes[:width])
+	}
+	return s
+}
+
+// showWhitespace makes spaces and tabs visible
+func showWhitespace(segment string) string {
+	return strings.NewReplacer(" ", "·", "\t", "→").Replace(segment)
+}
+
+// writeNewlineNote explains a trailing newline difference, which is
+// invisible in the diff itself
+func writeNewlineNote(sb *strings.Builder, expected, actual string, opts DiffOptions) {
+	var note string
+	switch expectedNewline, actualNewline := strings.HasSuffix(expected, "\n"), strings.HasSuffix(actual, "\n"); {
+	case expectedNewline && !actualNewline:
+		note = `\ no newline at end of actual`
+	case !expectedNewline && actualNewline:
+		note = `\ no newline at end of expected`
+	default:
+		return
+	}
+	sb.WriteString(colorize(note, noteStyle, opts.Color) + "\n")
+}
@@ -0,0 +1,178 @@
+package util
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderDiff(t *testing.T) {
+	testCases := []struct {
+		name     string
+		expected string
+		actual   string
+		want     string
+	}{
+		{
+			name:     "identical",
+			expected: "a\n",
+			actual:   "a\n",
+			want:     "",
+		},
+		{
+			name:     "single character",
+			expected: "version = 1.2.3;\nname = \"a\";\n",
+			actual:   "version = 1.2.4;\nname = \"a\";\n",
+			want: `--- expected
++++ actual
+@@ -1,2 +1,2 @@
+-version = 1.2.3;
+?              ^
++version = 1.2.4;
+?              ^
+ name = "a";
+`,
+		},
+		{
+			name:     "word and characters",
+			expected: "the quick brown fox\n",
+			actual:   "the quikc brown fox\n",
+			want: `--- expected
++++ actual
+@@ -1 +1 @@
+-the quick brown fox
+?    ~~~~^
++the quikc brown fox
+?    ~~~^~
+`,
+		},
+		{
+			name:     "whole word",
+			expected: "status: expected\n",
+			actual:   "status: actual\n",
+			want: `--- expected
++++ actual
+@@ -1 +1 @@
+-status: expected
+?        ^^^^^^^^
++status: actual
+?        ^^^^^^
+`,
+		},
+		{
+			name:     "whitespace and tabs",
+			expected: "a\tb c\n",
+			actual:   "a b  c\n",
+			want: `--- expected
++++ actual
+@@ -1 +1 @@
+-a→b c
+? ^
++a·b· c
+? ^ ^
+`,
+		},
+		{
+			name:     "trailing newline",
+			expected: "abc",
+			actual:   "abc\n",
+			want: `--- expected
++++ actual
+@@ -1 +1 @@
+-abc
++abc
+\ no newline at end of expected
+`,
+		},
+		{
+			name:     "unpaired lines are not highlighted",
+			expected: "a\n",
+			actual:   "a\nb\n",
+			want: `--- expected
++++ actual
+@@ -1 +1,2 @@
+ a
++b
+`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := RenderDiff(tc.expected, tc.actual, DiffOptions{Context: DefaultDiffContext})
+			if err != nil {
+				t.Fatalf("RenderDiff() error = %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("RenderDiff() mismatch:\n--- GOT ---\n%s\n--- WANT ---\n%s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRenderDiff_Color(t *testing.T) {
+	got, err := RenderDiff("version = 1.2.3\n", "version = 1.2.4\n", DiffOptions{Color: true, Context: DefaultDiffContext})
+	if err != nil {
+		t.Fatalf("RenderDiff() error = %v", err)
+	}
+	if strings.Contains(got, "?") {
+		t.Errorf("RenderDiff() with color should not contain guide lines, got %q", got)
+	}
+	if !strings.Contains(got, removedCharStyle.Sprint("3")) || !strings.Contains(got, addedCharStyle.Sprint("4")) {
+		t.Errorf("RenderDiff() should highlight the changed characters, got %q", got)
+	}
+	if StripANSI(got) != "--- expected\n+++ actual\n@@ -1 +1 @@\n-version = 1.2.3\n+version = 1.2.4\n" {
+		t.Errorf("RenderDiff() without colors = %q", StripANSI(got))
+	}
+}
+
+func TestRenderSideBySide(t *testing.T) {
+	expected := "a\nversion = 1.2.3;\nb\nremoved\n"
+	actual := "a\nversion = 1.2.4;\nb\nthe added line is long\nnew\n"
+
+	got, err := RenderSideBySide(expected, actual, DiffOptions{Context: 1, Width: 47})
+	if err != nil {
+		t.Fatalf("RenderSideBySide() error = %v", err)
+	}
+	// two columns of 22 runes with " | " in between
+	want := `expected                 actual
+@@ -1,4 +1,5 @@
+a                        a
+version = 1.2.3;       | version = 1.2.4;
+              ^        ?               ^
+b                        b
+removed                | the added line is long
+                       > new
+`
+	if got != want {
+		t.Errorf("RenderSideBySide() mismatch:\n--- GOT ---\n%s\n--- WANT ---\n%s", got, want)
+	}
+
+	got, err = RenderSideBySide(expected, actual, DiffOptions{Context: 1, Width: 23})
+	if err != nil {
+		t.Fatalf("RenderSideBySide() error = %v", err)
+	}
+	if !strings.Contains(got, "removed    | the added\n             line is lo\n             ng\n") {
+		t.Errorf("RenderSideBySide() should wrap long lines, got:\n%s", got)
+	}
+}
+
+func TestLimitLines(t *testing.T) {
+	testCases := []struct {
+		name  string
+		input string
+		max   int
+		want  string
+	}{
+		{name: "disabled", input: "a\nb\nc\n", max: 0, want: "a\nb\nc\n"},
+		{name: "within limit", input: "a\nb\n", max: 2, want: "a\nb\n"},
+		{name: "summarized", input: "a\nb\nc\nd\n", max: 2, want: "a\nb\n... 2 more lines not shown\n"},
+		{name: "without trailing newline", input: "a\nb\nc", max: 1, want: "a\n... 2 more lines not shown\n"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := LimitLines(tc.input, tc.max); got != tc.want {
+				t.Errorf("LimitLines() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
@@ -1,20 +1,29 @@
 package util
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
+	"path/filepath"
+	"regexp"
 	"strings"
+	"sync"
 
 	"github.com/akedrou/textdiff"
 	"github.com/akedrou/textdiff/myers"
 	apperrors "gitlab.com/TECHNOFAB/nixtest/internal/errors"
+	"gitlab.com/TECHNOFAB/nixtest/internal/types"
 )
 
-func ComputeDiff(expected, actual string) (string, error) {
+// ComputeDiff returns the unified diff of expected and actual with the given
+// amount of context lines
+func ComputeDiff(expected, actual string, context int) (string, error) {
 	// FIXME: ComputeEdits deprecated
 	edits := myers.ComputeEdits(expected, actual)
-	diff, err := textdiff.ToUnified("expected", "actual", expected, edits, 3)
+	diff, err := textdiff.ToUnified("expected", "actual", expected, edits, context)
 	if err != nil {
 		return "", err
 	}
@@ -23,7 +32,136 @@ func ComputeDiff(expected, actual string) (string, error) {
 	return diff, nil
 }
 
-// ParseFile reads and decodes a JSON file into the provided type
+// FormatMismatches renders mismatches as a structural diff with one
+// "<kind> <path>: <message>" line each, where kind is "+" (added), "-" (removed)
+// or "~" (changed). It ends with an empty line to separate it from a following diff
+func FormatMismatches(mismatches []types.Mismatch) string {
+	if len(mismatches) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	for _, m := range mismatches {
+		fmt.Fprintf(&sb, "%s %s: %s\n", mismatchSymbol(m.Kind), m.Path, m.Message)
+	}
+	sb.WriteString("\n")
+	return sb.String()
+}
+
+func mismatchSymbol(kind types.MismatchKind) string {
+	switch kind {
+	case types.MismatchAdded:
+		return "+"
+	case types.MismatchRemoved:
+		return "-"
+	default:
+		return "~"
+	}
+}
+
+// FormatDiff renders why a failed test's actual value differs from expected.
+// In the structural style mismatches below the root are shown as a
+// structural diff, followed by text diffs of changed multi-line strings.
+// Otherwise (like for two strings, where the root is the only path, or in the
+// unified and side-by-side style) the text diff of the serialized values is
+// shown after the mismatches if any.
+// The result is never empty and limited to opts.MaxLines.
+func FormatDiff(result types.TestResult, opts DiffOptions) (string, error) {
+	mismatches := FormatMismatches(result.Mismatches)
+	if opts.Color {
+		mismatches = colorMismatches(mismatches)
+	}
+	if opts.Style != DiffUnified && opts.Style != DiffSideBySide && isStructural(result.Mismatches) {
+		texts, err := textDiffs(result.Mismatches, opts)
+		if err != nil {
+			return "", err
+		}
+		return LimitLines(strings.TrimSuffix(mismatches+texts, "\n"), opts.MaxLines), nil
+	}
+
+	render := RenderDiff
+	if opts.Style == DiffSideBySide {
+		render = RenderSideBySide
+	}
+	diff, err := render(result.Expected, result.Actual, opts)
+	if err != nil {
+		return "", err
+	}
+	if diff == "" {
+		// a failing test should never show an empty diff
+		diff = "(no textual difference, the values only differ in type or formatting)\n"
+	}
+	return LimitLines(mismatches+diff, opts.MaxLines), nil
+}
+
+// textDiffs renders the text diffs of mismatches of multi-line strings, each
+// below its path and indented
+func textDiffs(mismatches []types.Mismatch, opts DiffOptions) (string, error) {
+	var sb strings.Builder
+	for _, m := range mismatches {
+		if m.Expected == "" && m.Actual == "" {
+			continue
+		}
+		diff, err := RenderDiff(m.Expected, m.Actual, opts)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&sb, "%s:\n%s\n\n", colorize(m.Path, noteStyle, opts.Color), PrefixLines(strings.TrimSuffix(diff, "\n"), "  "))
+	}
+	return sb.String(), nil
+}
+
+// colorMismatches colors the lines of FormatMismatches by their kind
+func colorMismatches(formatted string) string {
+	lines := strings.Split(formatted, "\n")
+	for i, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "+ "):
+			lines[i] = addedStyle.Sprint(line)
+		case strings.HasPrefix(line, "- "):
+			lines[i] = removedStyle.Sprint(line)
+		case strings.HasPrefix(line, "~ "):
+			lines[i] = noteStyle.Sprint(line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func isStructural(mismatches []types.Mismatch) bool {
+	for _, m := range mismatches {
+		if m.Path != "$" {
+			return true
+		}
+	}
+	return false
+}
+
+// ComparisonModes describes the non-default ways a test compares values,
+// like "subset, ignoreCase", empty if it compares exactly
+func ComparisonModes(spec types.TestSpec) string {
+	var modes []string
+	if spec.Match == types.MatchSubset {
+		modes = append(modes, string(types.MatchSubset))
+		if spec.SubsetLists {
+			modes = append(modes, "subsetLists")
+		}
+	}
+	for _, comparison := range spec.Comparison {
+		modes = append(modes, string(comparison))
+	}
+	if spec.Tolerance.Absolute != 0 {
+		modes = append(modes, fmt.Sprintf("absolute tolerance %g", spec.Tolerance.Absolute))
+	}
+	if spec.Tolerance.Relative != 0 {
+		modes = append(modes, fmt.Sprintf("relative tolerance %g", spec.Tolerance.Relative))
+	}
+	if spec.Numbers == types.NumbersExact {
+		modes = append(modes, "exact numbers")
+	}
+	return strings.Join(modes, ", ")
+}
+
+// ParseFile reads and decodes a JSON file into the provided type. Numbers in
+// untyped values are decoded as json.Number, see FloatNumbers
 func ParseFile[T any](filePath string) (result T, err error) {
 	file, err := os.Open(filePath)
 	if err != nil {
@@ -32,6 +170,7 @@ func ParseFile[T any](filePath string) (result T, err error) {
 	defer file.Close()
 
 	decoder := json.NewDecoder(file)
+	decoder.UseNumber()
 	err = decoder.Decode(&result)
 	if err != nil {
 		return result, &apperrors.JSONUnmarshalError{Source: filePath, Err: fmt.Errorf("failed to decode: %w", err)}
@@ -39,6 +178,33 @@ func ParseFile[T any](filePath string) (result T, err error) {
 	return result, nil
 }
 
+// FloatNumbers recursively converts every json.Number in value to float64,
+// like encoding/json decodes numbers by default
+func FloatNumbers(value any) any {
+	switch v := value.(type) {
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return v
+		}
+		return f
+	case map[string]any:
+		result := make(map[string]any, len(v))
+		for key, item := range v {
+			result[key] = FloatNumbers(item)
+		}
+		return result
+	case []any:
+		result := make([]any, len(v))
+		for i, item := range v {
+			result[i] = FloatNumbers(item)
+		}
+		return result
+	default:
+		return value
+	}
+}
+
 // PrefixLines adds a prefix to each line of the input string
 func PrefixLines(input string, prefix string) string {
 	lines := strings.Split(input, "\n")
@@ -48,7 +214,221 @@ func PrefixLines(input string, prefix string) string {
 	return strings.Join(lines, "\n")
 }
 
+var ansiRegex = regexp.MustCompile(`\x1b\[[0-9;]*[A-Za-z]`)
+
+// StripANSI removes ANSI escape sequences (colors etc.) from the input string
+func StripANSI(input string) string {
+	return ansiRegex.ReplaceAllString(input, "")
+}
+
 func IsString(value any) bool {
 	_, ok := value.(string)
 	return ok
 }
+
+// CopyDir recursively copies the contents of src into dst, making everything
+// writable for the owner (files from the Nix store are read-only)
+func CopyDir(src string, dst string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", src)
+	}
+
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm()|0700)
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		default:
+			return copyFile(path, target, info.Mode().Perm()|0200)
+		}
+	})
+}
+
+func copyFile(src string, dst string, perm fs.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// CappedBuffer is an io.Writer which only keeps the first and last half of
+// at most limit bytes written to it. Everything written is still passed on to
+// spill (if set), so the full output can be kept in a file for example.
+type CappedBuffer struct {
+	limit int
+	spill io.Writer
+	head  []byte
+	tail  []byte
+	total int64
+}
+
+// NewCappedBuffer creates a CappedBuffer, a limit <= 0 means unlimited
+func NewCappedBuffer(limit int, spill io.Writer) *CappedBuffer {
+	return &CappedBuffer{limit: limit, spill: spill}
+}
+
+func (b *CappedBuffer) Write(p []byte) (int, error) {
+	if b.spill != nil {
+		if _, err := b.spill.Write(p); err != nil {
+			return 0, err
+		}
+	}
+	b.total += int64(len(p))
+
+	if b.limit <= 0 {
+		b.head = append(b.head, p...)
+		return len(p), nil
+	}
+
+	headLimit := b.limit / 2
+	tailLimit := b.limit - headLimit
+	rest := p
+	if free := headLimit - len(b.head); free > 0 {
+		n := min(free, len(rest))
+		b.head = append(b.head, rest[:n]...)
+		rest = rest[n:]
+	}
+	b.tail = append(b.tail, rest...)
+	// only compact once in a while to keep writes cheap
+	if len(b.tail) > 2*tailLimit {
+		b.tail = append([]byte(nil), b.tail[len(b.tail)-tailLimit:]...)
+	}
+	return len(p), nil
+}
+
+// Truncated reports whether some of the written bytes were dropped
+func (b *CappedBuffer) Truncated() bool {
+	return b.limit > 0 && b.total > int64(b.limit)
+}
+
+// String returns the kept output, with a marker where bytes were elided
+func (b *CappedBuffer) String() string {
+	if !b.Truncated() {
+		return string(b.head) + string(b.tail)
+	}
+	tail := b.tail[len(b.tail)-(b.limit-b.limit/2):]
+	elided := b.total - int64(len(b.head)) - int64(len(tail))
+	return strings.ToValidUTF8(
+		fmt.Sprintf("%s\n[... %d bytes elided ...]\n%s", b.head, elided, tail),
+		"�",
+	)
+}
+
+// PrefixWriter is an io.Writer which writes every line to out with prefix
+// prepended. Incomplete lines are buffered until their newline or Flush.
+type PrefixWriter struct {
+	mu     sync.Mutex
+	out    io.Writer
+	prefix string
+	buf    []byte
+}
+
+func NewPrefixWriter(out io.Writer, prefix string) *PrefixWriter {
+	return &PrefixWriter{out: out, prefix: prefix}
+}
+
+func (w *PrefixWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf = append(w.buf, p...)
+	end := bytes.LastIndexByte(w.buf, '\n')
+	if end < 0 {
+		return len(p), nil
+	}
+	lines := w.buf[:end+1]
+	// write all complete lines at once so parallel tests don't interleave mid-line
+	var out []byte
+	for len(lines) > 0 {
+		i := bytes.IndexByte(lines, '\n')
+		out = append(out, w.prefix...)
+		out = append(out, lines[:i+1]...)
+		lines = lines[i+1:]
+	}
+	w.buf = append(w.buf[:0], w.buf[end+1:]...)
+	if _, err := w.out.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Flush writes the buffered incomplete line, if any
+func (w *PrefixWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.buf) == 0 {
+		return nil
+	}
+	out := append(append([]byte(w.prefix), w.buf...), '\n')
+	w.buf = w.buf[:0]
+	_, err := w.out.Write(out)
+	return err
+}
+
+// LineWriter is an io.Writer which calls fn for every line written to it,
+// without the line ending. Incomplete lines are buffered until their newline or Flush.
+type LineWriter struct {
+	fn  func(line string)
+	buf []byte
+}
+
+func NewLineWriter(fn func(line string)) *LineWriter {
+	return &LineWriter{fn: fn}
+}
+
+func (w *LineWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.fn(string(bytes.TrimRight(w.buf[:i], "\r")))
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush passes the buffered incomplete line to fn, if any
+func (w *LineWriter) Flush() {
+	if len(w.buf) == 0 {
+		return
+	}
+	w.fn(string(bytes.TrimRight(w.buf, "\r")))
+	w.buf = nil
+}
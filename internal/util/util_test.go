@@ -1,6 +1,7 @@
 package util
 
 import (
+	"encoding/json"
 	"errors"
 	"os"
 	"path/filepath"
@@ -9,6 +10,7 @@ import (
 	"testing"
 
 	apperrors "gitlab.com/TECHNOFAB/nixtest/internal/errors"
+	"gitlab.com/TECHNOFAB/nixtest/internal/types"
 )
 
 func TestComputeDiff(t *testing.T) {
@@ -91,7 +93,7 @@ func TestComputeDiff(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			gotDiff, err := ComputeDiff(tc.expected, tc.actual)
+			gotDiff, err := ComputeDiff(tc.expected, tc.actual, DefaultDiffContext)
 
 			if (err != nil) != tc.wantErr {
 				t.Errorf("ComputeDiff() error = %v, wantErr %v", err, tc.wantErr)
@@ -106,7 +108,7 @@ func TestComputeDiff(t *testing.T) {
 
 			if normalizedGotDiff != normalizedWantDiff {
 				t.Errorf("ComputeDiff() mismatch:\n--- GOT DIFF ---\n%s\n--- WANT DIFF ---\n%s", normalizedGotDiff, normalizedWantDiff)
-				metaDiff, _ := ComputeDiff(normalizedWantDiff, normalizedGotDiff)
+				metaDiff, _ := ComputeDiff(normalizedWantDiff, normalizedGotDiff, DefaultDiffContext)
 				if metaDiff != "" {
 					t.Errorf("--- DIFF OF DIFFS ---\n%s", metaDiff)
 				}
@@ -115,6 +117,147 @@ func TestComputeDiff(t *testing.T) {
 	}
 }
 
+func TestFormatDiff(t *testing.T) {
+	testCases := []struct {
+		name   string
+		opts   DiffOptions
+		result types.TestResult
+		want   string
+	}{
+		{
+			name: "structural",
+			result: types.TestResult{
+				Expected: "{\n  \"a\": 1\n}",
+				Actual:   "{\n  \"b\": 2\n}",
+				Mismatches: []types.Mismatch{
+					{Kind: types.MismatchRemoved, Path: "$.a", Message: "missing key, expected 1"},
+					{Kind: types.MismatchAdded, Path: "$.b", Message: "unexpected key with value 2"},
+					{Kind: types.MismatchChanged, Path: "$.c", Message: "expected 1, got 2"},
+				},
+			},
+			want: "- $.a: missing key, expected 1\n+ $.b: unexpected key with value 2\n~ $.c: expected 1, got 2\n",
+		},
+		{
+			name: "structural with text diffs of multi-line strings",
+			result: types.TestResult{
+				Mismatches: []types.Mismatch{
+					{Kind: types.MismatchAdded, Path: `$["bin/app"]`, Message: "unexpected key with value {}"},
+					{Path: `$["etc/app.conf"].content`, Message: "text differs, see the diff below", Expected: "port = 80\nhost = a\n", Actual: "port = 80\nhost = b\n"},
+				},
+			},
+			want: "+ $[\"bin/app\"]: unexpected key with value {}\n~ $[\"etc/app.conf\"].content: text differs, see the diff below\n\n" +
+				"$[\"etc/app.conf\"].content:\n  --- expected\n  +++ actual\n  @@ -1,2 +1,2 @@\n   port = 80\n  -host = a\n  ?       ^\n  +host = b\n  ?       ^\n",
+		},
+		{
+			name: "text diff fallback for root mismatches",
+			result: types.TestResult{
+				Expected:   "hello\n",
+				Actual:     "world\n",
+				Mismatches: []types.Mismatch{{Path: "$", Message: `expected "hello\n", got "world\n"`}},
+			},
+			want: "~ $: expected \"hello\\n\", got \"world\\n\"\n\n--- expected\n+++ actual\n@@ -1 +1 @@\n-hello\n+world\n",
+		},
+		{
+			name:   "text diff fallback without mismatches",
+			result: types.TestResult{Expected: "a\n", Actual: "b\n"},
+			want:   "--- expected\n+++ actual\n@@ -1 +1 @@\n-a\n+b\n",
+		},
+		{
+			name: "unified style shows the text diff too",
+			opts: DiffOptions{Style: DiffUnified, Context: 0},
+			result: types.TestResult{
+				Expected:   "{\n  \"a\": 1,\n  \"b\": 1\n}",
+				Actual:     "{\n  \"a\": 2,\n  \"b\": 1\n}",
+				Mismatches: []types.Mismatch{{Path: "$.a", Message: "expected 1, got 2"}},
+			},
+			want: "~ $.a: expected 1, got 2\n\n--- expected\n+++ actual\n@@ -2 +2 @@\n-  \"a\": 1,\n?       ^\n+  \"a\": 2,\n?       ^\n",
+		},
+		{
+			name: "max lines",
+			opts: DiffOptions{MaxLines: 2},
+			result: types.TestResult{
+				Mismatches: []types.Mismatch{
+					{Path: "$.a", Message: "expected 1, got 2"},
+					{Path: "$.b", Message: "expected 1, got 2"},
+					{Path: "$.c", Message: "expected 1, got 2"},
+				},
+			},
+			want: "~ $.a: expected 1, got 2\n~ $.b: expected 1, got 2\n... 1 more lines not shown\n",
+		},
+		{
+			name: "never empty",
+			result: types.TestResult{
+				Expected:   "1",
+				Actual:     "1",
+				Mismatches: []types.Mismatch{{Path: "$", Message: "type mismatch: expected int 1, got string \"1\""}},
+			},
+			want: "~ $: type mismatch: expected int 1, got string \"1\"\n\n(no textual difference, the values only differ in type or formatting)\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := tc.opts
+			if opts == (DiffOptions{}) {
+				opts.Context = DefaultDiffContext
+			}
+			got, err := FormatDiff(tc.result, opts)
+			if err != nil {
+				t.Fatalf("FormatDiff() error = %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("FormatDiff() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestComparisonModes(t *testing.T) {
+	testCases := []struct {
+		name string
+		spec types.TestSpec
+		want string
+	}{
+		{name: "exact", spec: types.TestSpec{}, want: ""},
+		{name: "subset", spec: types.TestSpec{Match: types.MatchSubset, SubsetLists: true}, want: "subset, subsetLists"},
+		{
+			name: "comparisons",
+			spec: types.TestSpec{Match: types.MatchExact, Comparison: []types.Comparison{types.CompareIgnoreCase, types.CompareUnorderedLists}},
+			want: "ignoreCase, unorderedLists",
+		},
+		{
+			name: "numbers",
+			spec: types.TestSpec{Tolerance: types.Tolerance{Absolute: 0.01, Relative: 1e-6}, Numbers: types.NumbersExact},
+			want: "absolute tolerance 0.01, relative tolerance 1e-06, exact numbers",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := ComparisonModes(tc.spec); got != tc.want {
+				t.Errorf("ComparisonModes() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestFloatNumbers(t *testing.T) {
+	input := map[string]any{
+		"int":    json.Number("42"),
+		"float":  json.Number("0.5"),
+		"list":   []any{json.Number("1"), "2", nil},
+		"nested": map[string]any{"a": json.Number("1e3")},
+	}
+	want := map[string]any{
+		"int":    42.0,
+		"float":  0.5,
+		"list":   []any{1.0, "2", nil},
+		"nested": map[string]any{"a": 1000.0},
+	}
+	if got := FloatNumbers(input); !reflect.DeepEqual(got, want) {
+		t.Errorf("FloatNumbers() = %#v, want %#v", got, want)
+	}
+}
+
 func TestParseFile(t *testing.T) {
 	type sampleStruct struct {
 		Name  string `json:"name"`
@@ -232,3 +375,114 @@ func TestIsString(t *testing.T) {
 		})
 	}
 }
+
+func TestCopyDir(t *testing.T) {
+	src := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(src, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "sub", "file.txt"), []byte("content"), 0444); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("sub/file.txt", filepath.Join(src, "link")); err != nil {
+		t.Fatal(err)
+	}
+	// mimic the read-only nix store
+	if err := os.Chmod(filepath.Join(src, "sub"), 0555); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(filepath.Join(src, "sub"), 0755)
+
+	dst := t.TempDir()
+	if err := CopyDir(src, dst); err != nil {
+		t.Fatalf("CopyDir() unexpected error: %v", err)
+	}
+
+	content, err := os.ReadFile(filepath.Join(dst, "sub", "file.txt"))
+	if err != nil || string(content) != "content" {
+		t.Errorf("CopyDir() file content = %q, err = %v", content, err)
+	}
+	if err := os.WriteFile(filepath.Join(dst, "sub", "file.txt"), []byte("changed"), 0644); err != nil {
+		t.Errorf("CopyDir() copied file should be writable: %v", err)
+	}
+	if link, err := os.Readlink(filepath.Join(dst, "link")); err != nil || link != "sub/file.txt" {
+		t.Errorf("CopyDir() symlink = %q, err = %v", link, err)
+	}
+
+	if err := CopyDir(filepath.Join(src, "sub", "file.txt"), dst); err == nil {
+		t.Errorf("CopyDir() expected error when source is not a directory")
+	}
+}
+
+func TestCappedBuffer(t *testing.T) {
+	t.Run("Unlimited", func(t *testing.T) {
+		buf := NewCappedBuffer(0, nil)
+		_, _ = buf.Write([]byte("hello "))
+		_, _ = buf.Write([]byte("world"))
+		if buf.Truncated() || buf.String() != "hello world" {
+			t.Errorf("CappedBuffer = %q (truncated %v), want %q", buf.String(), buf.Truncated(), "hello world")
+		}
+	})
+
+	t.Run("Below limit", func(t *testing.T) {
+		buf := NewCappedBuffer(20, nil)
+		_, _ = buf.Write([]byte("hello world"))
+		if buf.Truncated() || buf.String() != "hello world" {
+			t.Errorf("CappedBuffer = %q (truncated %v), want %q", buf.String(), buf.Truncated(), "hello world")
+		}
+	})
+
+	t.Run("Keeps head and tail", func(t *testing.T) {
+		var spill strings.Builder
+		buf := NewCappedBuffer(8, &spill)
+		for _, chunk := range []string{"abc", "defghij", "klmnopqrstu", "vwxyz"} {
+			if n, err := buf.Write([]byte(chunk)); err != nil || n != len(chunk) {
+				t.Fatalf("Write() = %d, %v", n, err)
+			}
+		}
+		want := "abcd\n[... 18 bytes elided ...]\nwxyz"
+		if !buf.Truncated() || buf.String() != want {
+			t.Errorf("CappedBuffer = %q (truncated %v), want %q", buf.String(), buf.Truncated(), want)
+		}
+		if spill.String() != "abcdefghijklmnopqrstuvwxyz" {
+			t.Errorf("spill = %q, want everything", spill.String())
+		}
+	})
+}
+
+func TestPrefixWriter(t *testing.T) {
+	var out strings.Builder
+	w := NewPrefixWriter(&out, "[test] ")
+	for _, chunk := range []string{"hel", "lo\nwor", "ld\n\nfoo"} {
+		if n, err := w.Write([]byte(chunk)); err != nil || n != len(chunk) {
+			t.Fatalf("Write() = %d, %v", n, err)
+		}
+	}
+	if want := "[test] hello\n[test] world\n[test] \n"; out.String() != want {
+		t.Errorf("before Flush() output = %q, want %q", out.String(), want)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush() unexpected error = %v", err)
+	}
+	if want := "[test] hello\n[test] world\n[test] \n[test] foo\n"; out.String() != want {
+		t.Errorf("after Flush() output = %q, want %q", out.String(), want)
+	}
+}
+
+func TestLineWriter(t *testing.T) {
+	var lines []string
+	w := NewLineWriter(func(line string) { lines = append(lines, line) })
+	for _, chunk := range []string{"hel", "lo\r\nwor", "ld\n\nfoo"} {
+		if n, err := w.Write([]byte(chunk)); err != nil || n != len(chunk) {
+			t.Fatalf("Write() = %d, %v", n, err)
+		}
+	}
+	if want := []string{"hello", "world", ""}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("before Flush() lines = %q, want %q", lines, want)
+	}
+	w.Flush()
+	w.Flush()
+	if want := []string{"hello", "world", "", "foo"}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("after Flush() lines = %q, want %q", lines, want)
+	}
+}
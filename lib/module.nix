@@ -15,6 +15,9 @@
     generators
     literalExpression
     xor
+    isDerivation
+    getExe
+    elem
     ;
 
   nixtest-lib = import ./default.nix {inherit pkgs lib;};
@@ -73,9 +76,9 @@
           in "${fileRelative}:${toString val.line}";
       };
       type = mkOption {
-        type = types.enum ["unit" "snapshot" "script" "vm"];
+        type = types.enum ["unit" "snapshot" "script" "vm" "eval" "throws" "build" "buildFails" "tree"];
         description = ''
-          Type of test, has to be one of "unit", "snapshot", "script", or "vm".
+          Type of test, has to be one of "unit", "snapshot", "script", "vm", "eval", "throws", "build", "buildFails", or "tree".
         '';
         default = "unit";
         apply = value:
@@ -84,7 +87,15 @@
           assert assertMsg (value == "unit" -> !isUnset config.expected)
           "test '${config.name}' as type 'unit' requires 'expected' to be set";
           assert assertMsg (value == "unit" -> (xor (isUnset config.actual) (isUnset config.actualDrv)))
-          "test '${config.name}' as type 'unit' requires only 'actual' OR 'actualDrv' to be set"; value;
+          "test '${config.name}' as type 'unit' requires only 'actual' OR 'actualDrv' to be set";
+          assert assertMsg (value == "eval" -> !isUnset config.expected)
+          "test '${config.name}' as type 'eval' requires 'expected' to be set";
+          assert assertMsg (elem value ["eval" "throws"] -> (xor (isUnset config.flake) (isUnset config.file)))
+          "test '${config.name}' as type '${value}' requires only 'flake' OR 'file' to be set";
+          assert assertMsg (elem value ["eval" "throws"] && !isUnset config.flake -> !isUnset config.attr)
+          "test '${config.name}' as type '${value}' with 'flake' requires 'attr' to be set";
+          assert assertMsg (elem value ["build" "buildFails" "tree"] -> !isUnset config.derivation)
+          "test '${config.name}' as type '${value}' requires 'derivation' to be set"; value;
       };
       name = mkOption {
         type = types.str;
@@ -142,18 +153,268 @@
           then val
           else builtins.unsafeDiscardStringContext (val.drvPath or "");
       };
-      script = mkUnsetOption {
+      actualFormat = mkOption {
+        type = types.enum ["json" "text" "yaml" "toml"];
+        description = ''
+          Format of the output of [`actualDrv`](#suitesnametestsactualdrv).
+
+          - `json`: parsed as JSON
+          - `text`: compared as a single string
+          - `yaml`, `toml`: parsed and compared like JSON, so matchers, `subset` matching and structural diffs
+            work the same. Dates and times become strings. YAML output must be a single document
+        '';
+        default = "json";
+      };
+      flake = mkUnsetOption {
         type = types.str;
+        description = ''
+          Flake reference to evaluate [`attr`](#suitesnametestsattr) from in `eval` and `throws` tests.
+          Nixtest evaluates it with `nix eval` when running the test, so evaluation errors
+          only fail this test instead of the whole suite.
+        '';
+        example = "path:/path/to/flake";
+      };
+      file = mkUnsetOption {
+        type = types.either types.path types.str;
+        description = ''
+          Nix file to evaluate (optionally [`attr`](#suitesnametestsattr) of it) in `eval` and `throws` tests,
+          like `nix eval --file`. Paths are passed as is and are not copied to the store.
+        '';
+        apply = val:
+          if isUnset val
+          then val
+          else toString val;
+      };
+      attr = mkUnsetOption {
+        type = types.str;
+        description = ''
+          Attribute path to evaluate in `eval` and `throws` tests, relative to [`flake`](#suitesnametestsflake)
+          or [`file`](#suitesnametestsfile).
+        '';
+        example = "lib.myFunction.result";
+      };
+      derivation = mkUnsetOption {
+        type = types.package;
+        description = ''
+          Derivation to build in `build`, `buildFails` and `tree` tests. Like [`actualDrv`](#suitesnametestsactualdrv)
+          it's only built when running the test.
+        '';
+        apply = val:
+          if isUnset val
+          then val
+          else builtins.unsafeDiscardStringContext (val.drvPath or "");
+      };
+      contains = mkUnsetOption {
+        type = types.str;
+        description = ''
+          Text the error message of a `throws` test or the build log of a `buildFails` test has to contain.
+          The message is what was passed to `throw`, `abort` etc. or the failed assertion, without the trace.
+          The build log is the full log of the failed builder (`nix log`).
+        '';
+        example = "invalid version";
+      };
+      pattern = mkUnsetOption {
+        type = types.str;
+        description = ''
+          Like [`contains`](#suitesnametestscontains), but a regular expression (Go syntax) which has to
+          match the error message or build log. If both are set both have to match.
+        '';
+        example = "^invalid version: .*$";
+      };
+      match = mkOption {
+        type = types.enum ["exact" "subset"];
+        description = ''
+          How [`expected`](#suitesnametestsexpected) is compared to the actual value.
+
+          - `exact`: both have to be equal
+          - `subset`: `expected` only needs to be contained in the actual value, so attrsets
+            (also nested ones) can have more attributes. See [`subsetLists`](#suitesnametestssubsetlists) for lists
+        '';
+        default = "exact";
+      };
+      subsetLists = mkOption {
+        type = types.bool;
+        description = ''
+          In `subset` [`match`](#suitesnametestsmatch) mode, also allow lists to contain more elements than expected,
+          in any order.
+        '';
+        default = false;
+      };
+      comparison = mkUnsetOption {
+        type = types.listOf (types.enum ["ignoreWhitespace" "ignoreCase" "ignoreTrailingNewline" "unorderedLists"]);
+        description = ''
+          Loosens how values are compared, failures show which of these were used.
+
+          - `ignoreWhitespace`: runs of whitespace in strings count as a single space, leading and trailing whitespace is ignored
+          - `ignoreCase`: strings are compared case-insensitively
+          - `ignoreTrailingNewline`: trailing newlines of strings are ignored
+          - `unorderedLists`: the order of list elements doesn't matter (their count does), useful for
+            attribute names or `lib.unique` output
+        '';
+        example = ["ignoreTrailingNewline" "unorderedLists"];
+      };
+      tolerance = mkOption {
+        type = types.submodule {
+          options = {
+            absolute = mkUnsetOption {
+              type = types.number;
+              description = ''
+                Maximum difference between expected and actual numbers.
+              '';
+              example = 0.001;
+            };
+            relative = mkUnsetOption {
+              type = types.number;
+              description = ''
+                Maximum difference relative to the larger of both numbers, `0.01` allows them to differ by 1%.
+              '';
+              example = 0.01;
+            };
+          };
+        };
+        description = ''
+          Tolerance for comparing numbers, applied to every number in expected and actual.
+          Numbers are equal if either tolerance is met.
+        '';
+        default = {};
+      };
+      numbers = mkOption {
+        type = types.enum ["float" "exact"];
+        description = ''
+          How numbers are compared.
+
+          - `float`: as 64-bit floats, so integers above 2^53 are rounded
+          - `exact`: as written, large integers are compared exactly
+        '';
+        default = "float";
+      };
+      ignore = mkUnsetOption {
+        type = types.listOf types.str;
+        description = ''
+          Paths which are removed from the actual and expected value before comparing and
+          before writing snapshots, for volatile values like timestamps.
+          Paths are JSON pointers (`/a/b/0`) or paths like `a.b[0]`, every segment can be a glob
+          (`a.*.id`, `items[*].id`) and `**` matches any number of segments (`**.timestamp`).
+        '';
+        example = ["**.timestamp" "/build/id"];
+      };
+      redact = mkUnsetOption {
+        type = types.listOf types.str;
+        description = ''
+          Like [`ignore`](#suitesnametestsignore), but replaces the values with `"[redacted]"`
+          instead of removing them, so they still have to exist.
+        '';
+        example = ["meta.hash"];
+      };
+      storePaths = mkOption {
+        type = types.enum ["keep" "normalize"];
+        description = ''
+          How `/nix/store` paths in the actual and expected value are handled before comparing
+          and before writing snapshots.
+
+          - `keep`: leave them as is
+          - `normalize`: replace their hashes, `/nix/store/<hash>-name` becomes `/nix/store/<HASH>-name`,
+            so snapshots don't change with every dependency bump
+        '';
+        default = "keep";
+      };
+      script = mkUnsetOption {
+        type = types.either types.str types.package;
         description = ''
           Script to run for the test.
           Nixtest will run this, failing the test if it exits with a non-zero exit code.
+
+          Can also be a derivation, which is then either passed to the [`interpreter`](#suitesnametestsinterpreter)
+          or, if [`entrypoint`](#suitesnametestsentrypoint) is set, `$out/bin/<entrypoint>` is run directly.
         '';
         apply = val:
           if isUnset val
           then val
+          else if isDerivation val
+          then builtins.unsafeDiscardStringContext val.drvPath
           else
             builtins.unsafeDiscardStringContext
-            (pkgs.writeShellScript "nixtest-${config.name}" val).drvPath;
+            (
+              if isUnset config.interpreter
+              then pkgs.writeShellScript "nixtest-${config.name}" val
+              else pkgs.writeText "nixtest-${config.name}" val
+            ).drvPath;
+      };
+      interpreter = mkUnsetOption {
+        type = types.either types.str types.package;
+        description = ''
+          Interpreter to run the [`script`](#suitesnametestsscript) with, defaults to `bash`.
+          If a derivation is passed, its main program is used.
+        '';
+        example = literalExpression "pkgs.python3";
+        apply = val:
+          if isUnset val || !(isDerivation val)
+          then val
+          else getExe val;
+      };
+      entrypoint = mkUnsetOption {
+        type = types.str;
+        description = ''
+          Name of the program in the `bin/` directory of the [`script`](#suitesnametestsscript) derivation to run directly,
+          for example a compiled test binary.
+        '';
+        example = "my-test";
+      };
+      args = mkUnsetOption {
+        type = types.listOf types.str;
+        description = ''
+          Arguments to pass to the script.
+        '';
+      };
+      stdin = mkUnsetOption {
+        type = types.str;
+        description = ''
+          Input to feed to the script's standard input.
+        '';
+      };
+      limits = mkOption {
+        type = types.submodule {
+          options = {
+            memory = mkUnsetOption {
+              type = types.ints.positive;
+              description = ''
+                Maximum address space of the script in bytes (`ulimit -v`).
+              '';
+              example = literalExpression "512 * 1024 * 1024";
+            };
+            cpuTime = mkUnsetOption {
+              type = types.ints.positive;
+              description = ''
+                Maximum CPU time of the script in seconds (`ulimit -t`).
+              '';
+            };
+            openFiles = mkUnsetOption {
+              type = types.ints.positive;
+              description = ''
+                Maximum number of open file descriptors of the script (`ulimit -n`).
+              '';
+            };
+          };
+        };
+        description = ''
+          Resource limits for script tests, applied using rlimits.
+          Scripts exceeding them fail with the reason shown in the failure output.
+        '';
+        default = {};
+      };
+      fixtures = mkUnsetOption {
+        type = types.either types.package types.path;
+        description = ''
+          Store path or derivation whose contents are copied (writable) into the working directory
+          of the script before it runs. Only used by "script" and "vm" tests.
+        '';
+        example = literalExpression "./fixtures";
+        apply = val:
+          if isUnset val
+          then val
+          else if isDerivation val
+          then builtins.unsafeDiscardStringContext val.drvPath
+          else "${val}";
       };
       vmConfig = mkUnsetOption {
         type = types.attrs;
@@ -179,35 +440,31 @@
       };
     };
     config = {
-      finalConfig = builtins.addErrorContext "[nixtest] while processing test ${config.name}" {
-        inherit (config) name expected actual actualDrv;
-        type =
-          if config.type == "vm"
-          then "script"
-          else config.type;
-        script =
-          if config.type == "vm"
-          then
-            assert assertMsg ((!isUnset config.vmConfig) && (config.vmConfig ? nodes) && (config.vmConfig ? testScript))
-            "test '${config.name}' as type 'vm' requires 'vmConfig' to be set and contain 'nodes' & 'testScript'"; let
-              inherit
-                (pkgs.testers.nixosTest (
-                  {
-                    name = "nixtest-vm-${config.name}";
-                  }
-                  // config.vmConfig
-                ))
-                driver
-                ;
-            in
-              builtins.unsafeDiscardStringContext
-              (pkgs.writeShellScript "nixtest-vm-${config.name}" ''
-                # use different TMPDIR to prevent race conditions:
-                #  vde_switch: Could not bind to socket '/tmp/vde1.ctl/ctl': Address already in use
-                TMPDIR=$(${pkgs.coreutils}/bin/mktemp -d) ${driver}/bin/nixos-test-driver
-              '').drvPath
-          else config.script;
-      };
+      finalConfig = let
+        vmDriver =
+          assert assertMsg ((!isUnset config.vmConfig) && (config.vmConfig ? nodes) && (config.vmConfig ? testScript))
+          "test '${config.name}' as type 'vm' requires 'vmConfig' to be set and contain 'nodes' & 'testScript'"; let
+            inherit
+              (pkgs.testers.nixosTest (
+                {
+                  name = "nixtest-vm-${config.name}";
+                }
+                // config.vmConfig
+              ))
+              driver
+              ;
+          in
+            driver;
+      in
+        builtins.addErrorContext "[nixtest] while processing test ${config.name}" {
+          inherit (config) name type expected actual actualDrv actualFormat flake file attr derivation contains pattern match subsetLists comparison tolerance numbers ignore redact storePaths script fixtures interpreter entrypoint args stdin limits;
+          # the runner starts the driver itself with its own TMPDIR and collects
+          # screenshots and serial logs as artifacts
+          driver =
+            if config.type == "vm"
+            then builtins.unsafeDiscardStringContext vmDriver.drvPath
+            else unset;
+        };
     };
   };
 
@@ -4,4 +4,16 @@
   scriptHelpers = builtins.readFile ./scriptHelpers.sh;
   toJsonFile = any: builtins.toFile "actual" (builtins.unsafeDiscardStringContext (builtins.toJSON any));
   toPrettyFile = any: builtins.toFile "actual" (lib.generators.toPretty {} any);
+  # matchers can be used anywhere in `expected` of unit and eval tests to check
+  # values loosely, snapshots are always compared literally
+  matchers = {
+    regex = regex: {"$regex" = regex;};
+    contains = value: {"$contains" = value;};
+    type = type: {"$type" = type;};
+    any = {"$any" = true;};
+    approx = value: tol: {
+      "$approx" = value;
+      "$tol" = tol;
+    };
+  };
 }
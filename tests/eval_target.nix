@@ -0,0 +1,15 @@
+# evaluated by the eval and throws tests in lib_test.nix
+let
+  parseVersion = v: let
+    parts = builtins.match "([0-9]+)\\.([0-9]+)" v;
+  in
+    if parts == null
+    then throw "invalid version: ${v}"
+    else {
+      major = builtins.fromJSON (builtins.elemAt parts 0);
+      minor = builtins.fromJSON (builtins.elemAt parts 1);
+    };
+in {
+  valid = parseVersion "1.2";
+  invalid = parseVersion "1.x";
+}
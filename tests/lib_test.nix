@@ -97,4 +97,209 @@
       }
     ];
   };
+  suites."Comparison Tests" = {
+    pos = __curPos;
+    tests = [
+      {
+        name = "matchers";
+        expected = with ntlib.helpers.matchers; {
+          version = regex "^[0-9]+\\.[0-9]+$";
+          tags = contains "stable";
+          name = type "string";
+          id = any;
+          ratio = approx 0.5 0.01;
+        };
+        actual = {
+          version = "1.2";
+          tags = ["beta" "stable"];
+          name = "nixtest";
+          id = 42;
+          ratio = 0.505;
+        };
+      }
+      {
+        name = "escaped matcher keys";
+        # "$$" stands for a literal "$", so this is compared as data
+        expected = {"$$regex" = "^v";};
+        actual = {"$regex" = "^v";};
+      }
+      {
+        name = "subset match";
+        match = "subset";
+        expected = {services.nginx.enable = true;};
+        actual = {
+          services.nginx = {
+            enable = true;
+            user = "nginx";
+          };
+          networking.hostName = "test";
+        };
+      }
+      {
+        name = "subset lists";
+        match = "subset";
+        subsetLists = true;
+        expected = {list = [3 1];};
+        actual = {list = [1 2 3];};
+      }
+      {
+        name = "string comparisons";
+        comparison = ["ignoreWhitespace" "ignoreCase" "ignoreTrailingNewline"];
+        expected = "Hello  World";
+        actual = "hello world\n";
+      }
+      {
+        name = "unordered lists";
+        comparison = ["unorderedLists"];
+        expected = ["b" "a" "a"];
+        actual = ["a" "b" "a"];
+      }
+      {
+        name = "unordered lists with matchers";
+        comparison = ["unorderedLists"];
+        expected = with ntlib.helpers.matchers; [(type "string") "a"];
+        actual = ["a" "b"];
+      }
+      {
+        name = "absolute tolerance";
+        tolerance.absolute = 0.01;
+        expected = {pi = 3.14;};
+        actual = {pi = 3.14159;};
+      }
+      {
+        name = "relative tolerance";
+        tolerance.relative = 0.01;
+        expected = 1000;
+        actual = 1005;
+      }
+      {
+        name = "exact numbers";
+        numbers = "exact";
+        expected = [9007199254740993 1.0];
+        actual = [9007199254740993 1];
+      }
+      {
+        name = "ignored paths";
+        ignore = ["**.timestamp"];
+        expected = {
+          build = {
+            id = 1;
+            timestamp = 0;
+          };
+        };
+        actual = {
+          build = {
+            id = 1;
+            timestamp = 1700000000;
+          };
+        };
+      }
+      {
+        name = "redacted paths";
+        redact = ["meta.hash"];
+        expected = {
+          meta = {
+            name = "app";
+            hash = "sha256-old";
+          };
+        };
+        actual = {
+          meta = {
+            name = "app";
+            hash = "sha256-new";
+          };
+        };
+      }
+      {
+        name = "normalized store paths";
+        storePaths = "normalize";
+        expected = "/nix/store/00000000000000000000000000000000-hello-2.12/bin/hello";
+        actual = "/nix/store/aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa-hello-2.12/bin/hello";
+      }
+      {
+        name = "text actualDrv";
+        actualFormat = "text";
+        actualDrv = pkgs.writeText "greeting" "hello\n";
+        expected = "hello\n";
+      }
+      {
+        name = "yaml actualDrv";
+        actualFormat = "yaml";
+        actualDrv = pkgs.writeText "config.yaml" ''
+          name: app
+          replicas: 3
+          tags: [a, b]
+        '';
+        expected = {
+          name = "app";
+          replicas = 3;
+          tags = ["a" "b"];
+        };
+      }
+      {
+        name = "toml actualDrv";
+        actualFormat = "toml";
+        actualDrv = pkgs.writeText "config.toml" ''
+          title = "app"
+          ports = [80, 443]
+        '';
+        expected = {
+          title = "app";
+          ports = [80 443];
+        };
+      }
+    ];
+  };
+  suites."Nix Tests" = {
+    pos = __curPos;
+    tests = [
+      {
+        name = "eval";
+        type = "eval";
+        file = ./eval_target.nix;
+        attr = "valid";
+        expected = {
+          major = 1;
+          minor = 2;
+        };
+      }
+      {
+        name = "throws";
+        type = "throws";
+        file = ./eval_target.nix;
+        attr = "invalid";
+        contains = "invalid version";
+        pattern = "^invalid version: 1\\.x$";
+      }
+      {
+        name = "build";
+        type = "build";
+        derivation = pkgs.runCommand "nixtest-build" {} ''
+          touch $out
+        '';
+      }
+      {
+        name = "build fails";
+        type = "buildFails";
+        derivation = pkgs.runCommand "nixtest-build-fails" {} ''
+          echo "checking config"
+          echo "error: port must be a number" >&2
+          exit 1
+        '';
+        contains = "port must be a number";
+      }
+      {
+        name = "tree output";
+        type = "tree";
+        # compared to snapshots/tree_output.snap.json
+        derivation = pkgs.runCommand "nixtest-tree" {} ''
+          mkdir -p $out/bin
+          echo hello > $out/hello.txt
+          printf '#!/bin/sh\necho hi\n' > $out/bin/hi
+          chmod +x $out/bin/hi
+          ln -s bin/hi $out/link
+        '';
+      }
+    ];
+  };
 }